@@ -55,14 +55,25 @@ func checkoutMain(opts *CheckoutOpts, version string) error {
 
 	url := fmt.Sprintf("%s/api/v1/projects/%s/pins/%v", cfg.Remote.Server, cfg.Remote.Project, opts.Version)
 
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	if err := config.Authorize(req, cfg.Remote.Server); err != nil {
+		return err
+	}
+
 	// Make the GET request to fetch the version
-	resp, err := http.Get(url)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to make request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	// Check if the request was successful
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("not logged in to %s, run \"stewdio login\"", cfg.Remote.Server)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("server returned error: %s", resp.Status)
 	}
@@ -1,135 +1,203 @@
 package compare
 
 import (
-	"encoding/binary"
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
-	"math"
+	"io"
 	"os"
 	"path/filepath"
 
-	"github.com/go-audio/wav"
+	"stewdio/cmd/pin"
+	"stewdio/internal/codec"
+	"stewdio/internal/wavfile"
 )
 
+// ErrFormatMismatch means two files hold audio in different sample
+// formats, so their samples cannot be compared.
+var ErrFormatMismatch = errors.New("audio formats differ")
+
 func GenerateDiffs(oldFile, newFile *os.File, outputPath string) error {
-	oldDecoder := wav.NewDecoder(oldFile)
-	oldAudioBuf, err := oldDecoder.FullPCMBuffer()
+	hunks, err := DiffFiles(oldFile, newFile)
 	if err != nil {
 		return err
 	}
 
-	newDecoder := wav.NewDecoder(newFile)
-	newAudioBuf, err := newDecoder.FullPCMBuffer()
+	for _, hunk := range hunks {
+		if err := pin.WriteHunk(outputPath, hunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DiffFiles computes the hunks that turn the audio in oldFile into the
+// audio in newFile, without writing them anywhere. Samples are compared
+// as stored, so any bit depth works, and frames are BlockAlign bytes.
+func DiffFiles(oldFile, newFile *os.File) ([]pin.Hunk, error) {
+	sourceHash, err := hashAndRewind(oldFile)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	oldWav, oldData, err := readSamples(oldFile)
+	if err != nil {
+		return nil, err
+	}
+	newWav, newData, err := readSamples(newFile)
+	if err != nil {
+		return nil, err
 	}
 
-	if oldDecoder.BitDepth != newDecoder.BitDepth {
-		return fmt.Errorf("bit depth mismatch: old file is %d-bit, new file is %d-bit", oldDecoder.BitDepth, newDecoder.BitDepth)
+	oldFormat, newFormat := oldWav.Format, newWav.Format
+	if oldFormat.BitsPerSample != newFormat.BitsPerSample {
+		return nil, fmt.Errorf("%w: old file is %d-bit, new file is %d-bit", ErrFormatMismatch, oldFormat.BitsPerSample, newFormat.BitsPerSample)
+	}
+	if oldFormat.Channels != newFormat.Channels {
+		return nil, fmt.Errorf("%w: old file has %d channels, new file has %d", ErrFormatMismatch, oldFormat.Channels, newFormat.Channels)
+	}
+	if oldFormat.BlockAlign != newFormat.BlockAlign {
+		return nil, fmt.Errorf("%w: old file has %d-byte frames, new file has %d-byte frames", ErrFormatMismatch, oldFormat.BlockAlign, newFormat.BlockAlign)
+	}
+	if (oldFormat.AudioFormat == wavfile.FormatFloat) != (newFormat.AudioFormat == wavfile.FormatFloat) {
+		return nil, fmt.Errorf("%w: only one file holds float samples", ErrFormatMismatch)
+	}
+	if _, err := oldFormat.SampleDecoder(); err != nil {
+		return nil, err
 	}
 
-	additions, subtractions, offsets := calculateDiffs(oldAudioBuf.Data, newAudioBuf.Data, int(oldDecoder.BitDepth), getFormat(oldDecoder))
+	bitDepth := int(oldFormat.BitsPerSample)
+	channels := int(oldFormat.Channels)
+	frameBytes := int(oldFormat.BlockAlign)
+
+	// Payloads are residual-coded only when frames hold nothing but the
+	// samples; padded frames are stored raw.
+	var format codec.Header
+	if frameBytes == bitDepth/8*channels {
+		format = codec.Header{
+			BitDepth: bitDepth,
+			Channels: channels,
+			Float:    oldFormat.AudioFormat == wavfile.FormatFloat,
+		}
+	}
 
-	additionsLength := len(additions) / bitDepthToBytes(int(oldDecoder.BitDepth))
-	subtractionsLength := len(subtractions) / bitDepthToBytes(int(oldDecoder.BitDepth))
+	hunks := calculateDiffs(oldData, newData, frameBytes)
 
 	oldFileName := filepath.Base(oldFile.Name())
 
-	if additionsLength > 0 {
-		additionsFileName := fmt.Sprintf("%s/%s_a_offset%d_len%d.bin", outputPath, oldFileName, offsets["additions"], len(additions))
-		err = os.WriteFile(additionsFileName, additions, 0644)
-		if err != nil {
-			return err
-		}
+	for i := range hunks {
+		hunks[i].File = oldFileName
+		hunks[i].SourceHash = sourceHash
+		hunks[i].Format = format
 	}
 
-	if subtractionsLength > 0 {
-		subtractionsFileName := fmt.Sprintf("%s/%s_s_offset%d_len%d.bin", outputPath, oldFileName, offsets["subtractions"], len(subtractions))
-		err = os.WriteFile(subtractionsFileName, subtractions, 0644)
-		if err != nil {
-			return err
-		}
+	return hunks, nil
+}
+
+func hashAndRewind(f *os.File) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", f.Name(), err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return h.Sum(nil), nil
 }
 
-func getFormat(decoder *wav.Decoder) string {
-	if decoder.BitDepth == 32 && decoder.WavAudioFormat == 3 {
-		return "float"
+// readSamples parses a WAV file and reads its sample data as stored.
+func readSamples(f *os.File) (*wavfile.File, []byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
 	}
-	return "pcm"
-}
 
-func calculateDiffs(oldData, newData []int, bitDepth int, format string) ([]byte, []byte, map[string]int) {
-	additions := make([]byte, 0)
-	subtractions := make([]byte, 0)
-	offsets := map[string]int{"additions": -1, "subtractions": -1}
-
-	oldLen := len(oldData)
-	newLen := len(newData)
-	maxLen := max(newLen, oldLen)
-
-	for i := 0; i < maxLen; i++ {
-		if i < oldLen && i < newLen {
-			if oldData[i] != newData[i] {
-				if offsets["additions"] == -1 {
-					offsets["additions"] = i * bitDepthToBytes(bitDepth)
-				}
-				if offsets["subtractions"] == -1 {
-					offsets["subtractions"] = i * bitDepthToBytes(bitDepth)
-				}
-				additions = append(additions, intToBytes(newData[i], bitDepth, format)...)
-				subtractions = append(subtractions, intToBytes(oldData[i], bitDepth, format)...)
-			}
-		} else if i < oldLen {
-			if offsets["subtractions"] == -1 {
-				offsets["subtractions"] = i * bitDepthToBytes(bitDepth)
-			}
-			subtractions = append(subtractions, intToBytes(oldData[i], bitDepth, format)...)
-		} else {
-			if offsets["additions"] == -1 {
-				offsets["additions"] = i * bitDepthToBytes(bitDepth)
-			}
-			additions = append(additions, intToBytes(newData[i], bitDepth, format)...)
-		}
+	wav, err := wavfile.Parse(f, info.Size())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %w", f.Name(), err)
+	}
+
+	dataChunk := wav.Data()
+	data := make([]byte, dataChunk.Size)
+	if _, err := f.ReadAt(data, dataChunk.DataOffset()); err != nil {
+		return nil, nil, fmt.Errorf("failed to read samples from %s: %w", f.Name(), err)
 	}
 
-	return additions, subtractions, offsets
+	return wav, data, nil
 }
 
-func intToBytes(value int, bitDepth int, format string) []byte {
-	switch bitDepth {
-	case 16:
-		buf := make([]byte, 2)
-		binary.LittleEndian.PutUint16(buf, uint16(value))
-		return buf
-	case 32:
-		buf := make([]byte, 4)
-		if format == "float" {
-			binary.LittleEndian.PutUint32(buf, math.Float32bits(float32(value)))
-		} else {
-			binary.LittleEndian.PutUint32(buf, uint32(value))
+// Changed runs separated by fewer unchanged frames than this are folded
+// into a single replace hunk.
+const mergeGapFrames = 32
+
+// calculateDiffs compares two runs of sample data frame by frame. Runs
+// of changed frames within the shared length become replace ("r")
+// hunks; a longer new file adds an insert ("a") hunk for its tail, and a
+// shorter one a cut ("s") hunk holding the removed samples. Offsets are
+// all relative to the old data. Bytes after the last whole frame are
+// ignored.
+func calculateDiffs(oldData, newData []byte, frameBytes int) []pin.Hunk {
+	var hunks []pin.Hunk
+
+	oldFrames := len(oldData) / frameBytes
+	newFrames := len(newData) / frameBytes
+	sharedFrames := min(oldFrames, newFrames)
+
+	frame := func(data []byte, i int) []byte {
+		return data[i*frameBytes : (i+1)*frameBytes]
+	}
+	frames := func(data []byte, from, to int) []byte {
+		return data[from*frameBytes : to*frameBytes : to*frameBytes]
+	}
+
+	runStart, runEnd := -1, -1
+	flush := func() {
+		if runStart == -1 {
+			return
 		}
-		return buf
-	default:
-		return nil
+		hunks = append(hunks, pin.Hunk{
+			Operation: "r",
+			Offset:    int64(runStart * frameBytes),
+			Length:    int64((runEnd - runStart) * frameBytes),
+			Data:      frames(newData, runStart, runEnd),
+		})
+		runStart, runEnd = -1, -1
 	}
-}
 
-func bitDepthToBytes(bitDepth int) int {
-	switch bitDepth {
-	case 16:
-		return 2
-	case 32:
-		return 4
-	default:
-		return 0
+	for i := 0; i < sharedFrames; i++ {
+		if bytes.Equal(frame(oldData, i), frame(newData, i)) {
+			continue
+		}
+		if runStart != -1 && i-runEnd >= mergeGapFrames {
+			flush()
+		}
+		if runStart == -1 {
+			runStart = i
+		}
+		runEnd = i + 1
+	}
+	flush()
+
+	if oldFrames > sharedFrames {
+		hunks = append(hunks, pin.Hunk{
+			Operation: "s",
+			Offset:    int64(sharedFrames * frameBytes),
+			Length:    int64((oldFrames - sharedFrames) * frameBytes),
+			Data:      frames(oldData, sharedFrames, oldFrames),
+		})
 	}
-}
 
-func max(a, b int) int {
-	if a > b {
-		return a
+	if newFrames > sharedFrames {
+		hunks = append(hunks, pin.Hunk{
+			Operation: "a",
+			Offset:    int64(sharedFrames * frameBytes),
+			Length:    int64((newFrames - sharedFrames) * frameBytes),
+			Data:      frames(newData, sharedFrames, newFrames),
+		})
 	}
-	return b
+
+	return hunks
 }
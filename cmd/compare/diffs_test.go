@@ -0,0 +1,169 @@
+package compare
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"stewdio/cmd/pin"
+	"stewdio/internal/testutil"
+	"stewdio/internal/wavfile"
+)
+
+func testFormat(bitDepth int, channels int, float bool) wavfile.Format {
+	format := wavfile.Format{
+		AudioFormat:   wavfile.FormatPCM,
+		Channels:      uint16(channels),
+		SampleRate:    44100,
+		BlockAlign:    uint16(bitDepth / 8 * channels),
+		BitsPerSample: uint16(bitDepth),
+	}
+	if float {
+		format.AudioFormat = wavfile.FormatFloat
+	}
+	format.ByteRate = format.SampleRate * uint32(format.BlockAlign)
+	return format
+}
+
+func readWAVData(t *testing.T, path string) []byte {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	_, data, err := readSamples(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func diffPaths(t *testing.T, oldPath, newPath string) ([]pin.Hunk, error) {
+	t.Helper()
+	oldFile, err := os.Open(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = oldFile.Close() }()
+	newFile, err := os.Open(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = newFile.Close() }()
+
+	return DiffFiles(oldFile, newFile)
+}
+
+func TestDiffFilesRoundTrip(t *testing.T) {
+	formats := []struct {
+		name     string
+		bitDepth int
+		float    bool
+	}{
+		{"8-bit", 8, false},
+		{"16-bit", 16, false},
+		{"24-bit", 24, false},
+		{"32-bit", 32, false},
+		{"32-bit float", 32, true},
+	}
+	edits := []struct {
+		name string
+		edit func(data []byte, frameBytes int) []byte
+	}{
+		{"replace", func(data []byte, frameBytes int) []byte {
+			for i := 100 * frameBytes; i < 150*frameBytes; i++ {
+				data[i] ^= 0xff
+			}
+			return data
+		}},
+		{"replace and extend", func(data []byte, frameBytes int) []byte {
+			data[10*frameBytes] ^= 1
+			return append(data, bytes.Repeat([]byte{7}, 40*frameBytes)...)
+		}},
+		{"cut", func(data []byte, frameBytes int) []byte {
+			return data[:len(data)-30*frameBytes]
+		}},
+	}
+
+	rng := rand.New(rand.NewPCG(5, 6))
+	for _, f := range formats {
+		for _, channels := range []int{1, 2} {
+			for _, e := range edits {
+				format := testFormat(f.bitDepth, channels, f.float)
+				frameBytes := int(format.BlockAlign)
+
+				oldData := make([]byte, 1000*frameBytes)
+				for i := range oldData {
+					oldData[i] = byte(rng.IntN(256))
+				}
+				newData := e.edit(append([]byte{}, oldData...), frameBytes)
+
+				dir := t.TempDir()
+				oldPath := filepath.Join(dir, "take.wav")
+				newPath := filepath.Join(dir, "new.wav")
+				testutil.WriteWAV(t, oldPath, format, oldData)
+				testutil.WriteWAV(t, newPath, format, newData)
+
+				hunks, err := diffPaths(t, oldPath, newPath)
+				if err != nil {
+					t.Fatalf("%s, %d channels, %s: %v", f.name, channels, e.name, err)
+				}
+				if len(hunks) == 0 {
+					t.Fatalf("%s, %d channels, %s: no hunks for changed audio", f.name, channels, e.name)
+				}
+
+				patchDir := filepath.Join(dir, "patches")
+				if err := os.Mkdir(patchDir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				for _, hunk := range hunks {
+					if err := pin.WriteHunk(patchDir, hunk); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := pin.ApplyPatchDir(oldPath, patchDir, pin.ApplyOptions{}); err != nil {
+					t.Fatalf("%s, %d channels, %s: apply: %v", f.name, channels, e.name, err)
+				}
+
+				if got := readWAVData(t, oldPath); !bytes.Equal(got, newData) {
+					t.Errorf("%s, %d channels, %s: patched audio differs from the new file", f.name, channels, e.name)
+				}
+			}
+		}
+	}
+}
+
+func TestDiffFilesUnchanged(t *testing.T) {
+	format := testFormat(24, 2, false)
+	data := bytes.Repeat([]byte{1, 2, 3, 4, 5, 6}, 500)
+
+	dir := t.TempDir()
+	testutil.WriteWAV(t, filepath.Join(dir, "a.wav"), format, data)
+	testutil.WriteWAV(t, filepath.Join(dir, "b.wav"), format, data)
+
+	hunks, err := diffPaths(t, filepath.Join(dir, "a.wav"), filepath.Join(dir, "b.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hunks) != 0 {
+		t.Errorf("got %d hunks for identical audio", len(hunks))
+	}
+}
+
+func TestDiffFilesFormatMismatch(t *testing.T) {
+	dir := t.TempDir()
+	testutil.WriteWAV(t, filepath.Join(dir, "a.wav"), testFormat(16, 2, false), make([]byte, 400))
+	testutil.WriteWAV(t, filepath.Join(dir, "b.wav"), testFormat(24, 2, false), make([]byte, 600))
+	testutil.WriteWAV(t, filepath.Join(dir, "c.wav"), testFormat(32, 2, true), make([]byte, 800))
+	testutil.WriteWAV(t, filepath.Join(dir, "d.wav"), testFormat(32, 2, false), make([]byte, 800))
+
+	for _, pair := range [][2]string{{"a.wav", "b.wav"}, {"c.wav", "d.wav"}} {
+		if _, err := diffPaths(t, filepath.Join(dir, pair[0]), filepath.Join(dir, pair[1])); err == nil {
+			t.Errorf("%s and %s: expected an error", pair[0], pair[1])
+		}
+	}
+}
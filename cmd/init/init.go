@@ -70,7 +70,7 @@ func initMain(opts *initOpts) error {
 	}
 	_ = cfg
 
-	err = pin_utils.Push(cwd, cfg.Remote, "0.1")
+	err = pin_utils.Push(cwd, cfg.Remote, "0.1", "")
 	if err != nil {
 		fmt.Println("error pushing initial pin version 0.1:", err)
 		return err
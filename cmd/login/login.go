@@ -0,0 +1,136 @@
+package login
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	cmdUtils "stewdio/internal/cmd/utils"
+	"stewdio/internal/config"
+	"stewdio/internal/utils"
+)
+
+type loginOpts struct {
+	Server string
+	Token  string
+}
+
+func LoginCmd() *cobra.Command {
+	opts := loginOpts{}
+
+	cmd := cobra.Command{
+		Use:   "login [SERVER]",
+		Short: "Save an API token for a sync server",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if err := cobra.MaximumNArgs(1)(cmd, args); err != nil {
+				return err
+			}
+
+			if len(args) > 0 {
+				opts.Server = args[0]
+			}
+
+			return nil
+		},
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdUtils.CommandErrorHandler(loginMain(cmd, &opts))
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.Token, "token", "t", "", "API token to save (default: read from stdin)")
+
+	cmd.SetHelpTemplate(cmd.HelpTemplate() + `
+Arguments:
+  [SERVER]   Server URL (default: the remote of the current project)
+
+Tokens are issued on the server with "stewdio server user add".
+`)
+	cmdUtils.SetHelpFlagText(&cmd)
+
+	return &cmd
+}
+
+func loginMain(cmd *cobra.Command, opts *loginOpts) error {
+	server := opts.Server
+	if server == "" {
+		cwd, _ := os.Getwd()
+		if !utils.PathExists(filepath.Join(cwd, ".stew")) {
+			msg := "error: no server given and this is not a stewdio repository"
+			fmt.Println(msg)
+			return fmt.Errorf("%s", msg)
+		}
+
+		cfg, err := config.ParseConfig(cwd)
+		if err != nil {
+			fmt.Println("error parsing config:", err)
+			return err
+		}
+		server = cfg.Remote.Server
+	}
+
+	token := opts.Token
+	if token == "" {
+		fmt.Printf("Token for %s: ", server)
+		line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Println("\nerror: no token given")
+			return err
+		}
+		token = line
+	}
+	token = strings.TrimSpace(token)
+
+	name, err := whoami(server, token)
+	if err != nil {
+		fmt.Println("error:", err)
+		return err
+	}
+
+	if err := config.SaveToken(server, token); err != nil {
+		fmt.Println("error: failed to save token:", err)
+		return err
+	}
+
+	fmt.Printf("Logged in to %s as %s\n", server, name)
+	return nil
+}
+
+// whoami checks a token against the server before it is saved.
+func whoami(server string, token string) (string, error) {
+	url := fmt.Sprintf("%s/api/v1/user", strings.TrimRight(server, "/"))
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return "", fmt.Errorf("the server rejected this token")
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("server returned error: %s", res.Status)
+	}
+
+	var user struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&user); err != nil {
+		return "", fmt.Errorf("invalid response: %w", err)
+	}
+
+	return user.Name, nil
+}
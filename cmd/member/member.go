@@ -0,0 +1,183 @@
+package member
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	cmdUtils "stewdio/internal/cmd/utils"
+	"stewdio/internal/config"
+	"stewdio/internal/utils"
+)
+
+func MemberCmd() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "member",
+		Short: "Manage who can access the current project on its remote",
+	}
+
+	cmd.AddCommand(listCmd())
+	cmd.AddCommand(addCmd())
+	cmd.AddCommand(removeCmd())
+	cmdUtils.SetHelpFlagText(&cmd)
+
+	return &cmd
+}
+
+func listCmd() *cobra.Command {
+	cmd := cobra.Command{
+		Use:          "list",
+		Short:        "List project members and their roles",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdUtils.CommandErrorHandler(listMain())
+		},
+	}
+	cmdUtils.SetHelpFlagText(&cmd)
+
+	return &cmd
+}
+
+func addCmd() *cobra.Command {
+	cmd := cobra.Command{
+		Use:          "add {USER} {ROLE}",
+		Short:        "Give a user a role in the project, or change it",
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdUtils.CommandErrorHandler(addMain(args[0], args[1]))
+		},
+	}
+
+	cmd.SetHelpTemplate(cmd.HelpTemplate() + `
+Arguments:
+  [USER]   Name of the user
+  [ROLE]   owner, writer or reader
+`)
+	cmdUtils.SetHelpFlagText(&cmd)
+
+	return &cmd
+}
+
+func removeCmd() *cobra.Command {
+	cmd := cobra.Command{
+		Use:          "remove {USER}",
+		Short:        "Take away a user's access to the project",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdUtils.CommandErrorHandler(removeMain(args[0]))
+		},
+	}
+
+	cmd.SetHelpTemplate(cmd.HelpTemplate() + `
+Arguments:
+  [USER]   Name of the user
+`)
+	cmdUtils.SetHelpFlagText(&cmd)
+
+	return &cmd
+}
+
+type member struct {
+	User string `json:"user"`
+	Role string `json:"role"`
+}
+
+func listMain() error {
+	var members []member
+	if err := request("GET", "", nil, &members); err != nil {
+		fmt.Println("error:", err)
+		return err
+	}
+
+	for _, m := range members {
+		fmt.Printf("%-8s %s\n", m.Role, m.User)
+	}
+	return nil
+}
+
+func addMain(user string, role string) error {
+	body, err := json.Marshal(map[string]string{"role": role})
+	if err != nil {
+		return err
+	}
+
+	if err := request("PUT", user, body, nil); err != nil {
+		fmt.Println("error:", err)
+		return err
+	}
+
+	fmt.Printf("%s is now %s\n", user, role)
+	return nil
+}
+
+func removeMain(user string) error {
+	if err := request("DELETE", user, nil, nil); err != nil {
+		fmt.Println("error:", err)
+		return err
+	}
+
+	fmt.Println("Removed", user)
+	return nil
+}
+
+// request calls the members endpoint of the current project, decoding
+// a JSON response into out if it is not nil.
+func request(method string, user string, body []byte, out any) error {
+	cwd, _ := os.Getwd()
+	if !utils.PathExists(filepath.Join(cwd, ".stew")) {
+		return fmt.Errorf("this is not a stewdio repository")
+	}
+
+	cfg, err := config.ParseConfig(cwd)
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v1/projects/%s/members", cfg.Remote.Server, url.PathEscape(cfg.Remote.Project))
+	if user != "" {
+		endpoint += "/" + url.PathEscape(user)
+	}
+
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err := config.Authorize(req, cfg.Remote.Server); err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("not logged in to %s, run \"stewdio login\"", cfg.Remote.Server)
+	}
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return fmt.Errorf("invalid response: %w", err)
+		}
+	}
+	return nil
+}
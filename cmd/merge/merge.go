@@ -0,0 +1,370 @@
+package merge
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"stewdio/cmd/compare"
+	"stewdio/cmd/pin"
+	cmdUtils "stewdio/internal/cmd/utils"
+	"stewdio/internal/wavfile"
+)
+
+type mergeOpts struct {
+	Base   string
+	Ours   string
+	Theirs string
+	Output string
+}
+
+func MergeCmd() *cobra.Command {
+	opts := mergeOpts{}
+
+	cmd := cobra.Command{
+		Use:   "merge {BASE} {OURS} {THEIRS}",
+		Short: "Combine two sets of edits made to the same audio file",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if err := cobra.ExactArgs(3)(cmd, args); err != nil {
+				return err
+			}
+
+			opts.Base = args[0]
+			opts.Ours = args[1]
+			opts.Theirs = args[2]
+
+			return nil
+		},
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdUtils.CommandErrorHandler(mergeMain(&opts))
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", "", "Where to write the merged file (default: OURS)")
+
+	cmd.SetHelpTemplate(cmd.HelpTemplate() + `
+Arguments:
+  [BASE]     The common ancestor both sides were edited from
+  [OURS]     Our edited version
+  [THEIRS]   Their edited version
+`)
+	cmdUtils.SetHelpFlagText(&cmd)
+
+	return &cmd
+}
+
+// Conflict is a stretch of the base audio that both sides changed in
+// different ways.
+type Conflict struct {
+	// Range of the base sample data, in bytes
+	Offset int64
+	Length int64
+	// What each side turned that range into
+	Ours   []byte
+	Theirs []byte
+	// Position of the range in the base audio
+	Start time.Duration
+	End   time.Duration
+}
+
+type Result struct {
+	// Hunks against the base that both sides agree on
+	Hunks     []pin.Hunk
+	Conflicts []Conflict
+	Format    wavfile.Format
+
+	// Source details shared by every hunk against the base
+	template pin.Hunk
+}
+
+func mergeMain(opts *mergeOpts) error {
+	output := opts.Output
+	if output == "" {
+		output = opts.Ours
+	}
+
+	result, err := MergeFiles(opts.Base, opts.Ours, opts.Theirs)
+	if err != nil {
+		fmt.Println("error: failed to merge:", err)
+		return err
+	}
+
+	// Conflicting regions keep our version until they are resolved.
+	hunks := append([]pin.Hunk{}, result.Hunks...)
+	for _, conflict := range result.Conflicts {
+		hunks = append(hunks, result.ConflictHunks(conflict, conflict.Ours)...)
+	}
+
+	if err := pin.WritePatched(opts.Base, output, hunks, pin.ApplyOptions{}); err != nil {
+		fmt.Println("error: failed to write merged file:", err)
+		return err
+	}
+
+	if len(result.Conflicts) > 0 {
+		if err := SaveState(output, opts.Base, result); err != nil {
+			fmt.Println("error: failed to save merge state:", err)
+			return err
+		}
+
+		fmt.Printf("Merged with %d conflicting regions:\n", len(result.Conflicts))
+		for i, conflict := range result.Conflicts {
+			fmt.Printf("  %d: %s - %s\n", i+1, FormatTimestamp(conflict.Start), FormatTimestamp(conflict.End))
+		}
+		fmt.Printf("Our version was kept in each region; run \"stewdio resolve %s\" to choose.\n", output)
+		return fmt.Errorf("merge has %d conflicts", len(result.Conflicts))
+	}
+
+	if err := ClearState(output); err != nil {
+		fmt.Println("error: failed to clear old merge state:", err)
+		return err
+	}
+
+	fmt.Println("Merged successfully into", output)
+	return nil
+}
+
+// MergeFiles diffs both sides against the base and combines the hunks
+// that do not overlap.
+func MergeFiles(basePath, oursPath, theirsPath string) (*Result, error) {
+	base, err := os.Open(basePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = base.Close() }()
+
+	info, err := base.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	wav, err := wavfile.Parse(base, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse base file: %w", err)
+	}
+
+	ours, err := diffAgainst(base, oursPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff ours: %w", err)
+	}
+
+	theirs, err := diffAgainst(base, theirsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff theirs: %w", err)
+	}
+
+	dataChunk := wav.Data()
+	samples := io.NewSectionReader(base, dataChunk.DataOffset(), dataChunk.Size)
+
+	return mergeHunks(ours, theirs, samples, wav.Format)
+}
+
+func diffAgainst(base *os.File, otherPath string) ([]pin.Hunk, error) {
+	other, err := os.Open(otherPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = other.Close() }()
+
+	if _, err := base.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return compare.DiffFiles(base, other)
+}
+
+type sidedHunk struct {
+	pin.Hunk
+	theirs bool
+}
+
+func (h sidedHunk) isInsert() bool {
+	return h.Operation == "a"
+}
+
+func (h sidedHunk) end() int64 {
+	if h.isInsert() {
+		return h.Offset
+	}
+	return h.Offset + h.Length
+}
+
+func mergeHunks(ours, theirs []pin.Hunk, samples io.ReaderAt, format wavfile.Format) (*Result, error) {
+	result := &Result{Format: format}
+	if all := append(append([]pin.Hunk{}, ours...), theirs...); len(all) > 0 {
+		result.template = pin.Hunk{
+			File:       all[0].File,
+			SourceHash: all[0].SourceHash,
+			Format:     all[0].Format,
+		}
+	}
+
+	var all []sidedHunk
+	for _, hunk := range ours {
+		all = append(all, sidedHunk{Hunk: hunk})
+	}
+	for _, hunk := range theirs {
+		all = append(all, sidedHunk{Hunk: hunk, theirs: true})
+	}
+
+	// Insertions sort ahead of ranges starting at the same offset, since
+	// adding audio right before a changed region does not touch it.
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].Offset != all[j].Offset {
+			return all[i].Offset < all[j].Offset
+		}
+		return all[i].isInsert() && !all[j].isInsert()
+	})
+
+	// Group hunks whose ranges overlap. Two insertions at the same
+	// offset overlap too, since their order would be ambiguous.
+	var groups [][]sidedHunk
+	var groupEnd int64
+	insertAtEnd := false
+	for _, hunk := range all {
+		touches := len(groups) > 0 &&
+			(hunk.Offset < groupEnd || (hunk.isInsert() && insertAtEnd && hunk.Offset == groupEnd))
+
+		if !touches {
+			groups = append(groups, nil)
+			groupEnd = hunk.Offset
+			insertAtEnd = false
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], hunk)
+
+		if hunk.end() > groupEnd {
+			groupEnd = hunk.end()
+			insertAtEnd = false
+		}
+		if hunk.isInsert() && hunk.Offset == groupEnd {
+			insertAtEnd = true
+		}
+	}
+
+	for _, group := range groups {
+		var oursGroup, theirsGroup []pin.Hunk
+		for _, hunk := range group {
+			if hunk.theirs {
+				theirsGroup = append(theirsGroup, hunk.Hunk)
+			} else {
+				oursGroup = append(oursGroup, hunk.Hunk)
+			}
+		}
+
+		switch {
+		case len(theirsGroup) == 0:
+			result.Hunks = append(result.Hunks, oursGroup...)
+		case len(oursGroup) == 0, sameHunks(oursGroup, theirsGroup):
+			result.Hunks = append(result.Hunks, theirsGroup...)
+		default:
+			conflict, err := newConflict(group, samples, format)
+			if err != nil {
+				return nil, err
+			}
+			result.Conflicts = append(result.Conflicts, conflict)
+		}
+	}
+
+	return result, nil
+}
+
+func sameHunks(a, b []pin.Hunk) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Operation != b[i].Operation || a[i].Offset != b[i].Offset ||
+			a[i].Length != b[i].Length || !bytes.Equal(a[i].Data, b[i].Data) {
+			return false
+		}
+	}
+	return true
+}
+
+func newConflict(group []sidedHunk, samples io.ReaderAt, format wavfile.Format) (Conflict, error) {
+	start, end := group[0].Offset, group[0].end()
+	for _, hunk := range group {
+		start = min(start, hunk.Offset)
+		end = max(end, hunk.end())
+	}
+
+	base := make([]byte, end-start)
+	if _, err := samples.ReadAt(base, start); err != nil && err != io.EOF {
+		return Conflict{}, fmt.Errorf("failed to read base audio: %w", err)
+	}
+
+	conflict := Conflict{
+		Offset: start,
+		Length: end - start,
+		Start:  offsetToDuration(start, format),
+		End:    offsetToDuration(end, format),
+	}
+
+	conflict.Ours = applyToRange(group, false, base, start)
+	conflict.Theirs = applyToRange(group, true, base, start)
+
+	return conflict, nil
+}
+
+// applyToRange produces one side's version of a stretch of base audio
+// that starts at offset.
+func applyToRange(group []sidedHunk, theirs bool, base []byte, offset int64) []byte {
+	var out []byte
+
+	cursor := int64(0)
+	for _, hunk := range group {
+		if hunk.theirs != theirs {
+			continue
+		}
+
+		from := hunk.Offset - offset
+		out = append(out, base[cursor:from]...)
+
+		switch hunk.Operation {
+		case "a":
+			out = append(out, hunk.Data...)
+			cursor = from
+		case "s":
+			cursor = from + hunk.Length
+		case "r":
+			out = append(out, hunk.Data...)
+			cursor = from + hunk.Length
+		}
+	}
+
+	return append(out, base[cursor:]...)
+}
+
+// ConflictHunks returns the hunks that settle a conflict on data.
+func (r *Result) ConflictHunks(conflict Conflict, data []byte) []pin.Hunk {
+	hunks := pin.ReplaceRange(conflict.Offset, conflict.Length, data)
+
+	for i := range hunks {
+		hunks[i].File = r.template.File
+		hunks[i].SourceHash = r.template.SourceHash
+		hunks[i].Format = r.template.Format
+	}
+
+	return hunks
+}
+
+func offsetToDuration(offset int64, format wavfile.Format) time.Duration {
+	if format.SampleRate == 0 {
+		return 0
+	}
+	frames := offset / int64(format.BlockAlign)
+	return time.Duration(frames) * time.Second / time.Duration(format.SampleRate)
+}
+
+// FormatTimestamp renders a position in the audio as m:ss.mmm.
+func FormatTimestamp(d time.Duration) string {
+	minutes := d / time.Minute
+	seconds := (d % time.Minute) / time.Second
+	millis := (d % time.Second) / time.Millisecond
+	return fmt.Sprintf("%d:%02d.%03d", minutes, seconds, millis)
+}
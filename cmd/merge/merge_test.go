@@ -0,0 +1,151 @@
+package merge
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"stewdio/cmd/pin"
+	"stewdio/internal/testutil"
+	"stewdio/internal/wavfile"
+)
+
+func insert(offset int64, data string) pin.Hunk {
+	return pin.Hunk{Operation: "a", Offset: offset, Length: int64(len(data)), Data: []byte(data)}
+}
+
+func cut(offset int64, length int64) pin.Hunk {
+	return pin.Hunk{Operation: "s", Offset: offset, Length: length}
+}
+
+func replace(offset int64, data string) pin.Hunk {
+	return pin.Hunk{Operation: "r", Offset: offset, Length: int64(len(data)), Data: []byte(data)}
+}
+
+type wantConflict struct {
+	offset int64
+	length int64
+	ours   string
+	theirs string
+}
+
+func TestMergeHunks(t *testing.T) {
+	base := []byte("0123456789")
+	// One byte per frame and per second, so offsets read as positions
+	format := wavfile.Format{Channels: 1, SampleRate: 1, BlockAlign: 1, BitsPerSample: 8}
+
+	cases := []struct {
+		name      string
+		ours      []pin.Hunk
+		theirs    []pin.Hunk
+		hunks     int
+		conflicts []wantConflict
+	}{
+		{"no edits", nil, nil, 0, nil},
+		{"only ours", []pin.Hunk{replace(2, "x")}, nil, 1, nil},
+		{"only theirs", nil, []pin.Hunk{cut(0, 2)}, 1, nil},
+		{"disjoint", []pin.Hunk{replace(1, "x")}, []pin.Hunk{cut(6, 2)}, 2, nil},
+		{"same edit", []pin.Hunk{replace(3, "ab")}, []pin.Hunk{replace(3, "ab")}, 1, nil},
+		{"adjacent ranges", []pin.Hunk{replace(2, "xy")}, []pin.Hunk{cut(4, 2)}, 2, nil},
+		{"insert before a changed range", []pin.Hunk{insert(5, "new")}, []pin.Hunk{replace(5, "zz")}, 2, nil},
+		{"insert after a changed range", []pin.Hunk{replace(3, "zz")}, []pin.Hunk{insert(5, "new")}, 2, nil},
+		{
+			"overlapping replaces", []pin.Hunk{replace(2, "abc")}, []pin.Hunk{replace(3, "XYZ")}, 0,
+			[]wantConflict{{2, 4, "abc5", "2XYZ"}},
+		},
+		{
+			"cut against replace", []pin.Hunk{cut(4, 3)}, []pin.Hunk{replace(5, "q")}, 0,
+			[]wantConflict{{4, 3, "", "4q6"}},
+		},
+		{
+			"inserts at the same offset", []pin.Hunk{insert(5, "ab")}, []pin.Hunk{insert(5, "cd")}, 0,
+			[]wantConflict{{5, 0, "ab", "cd"}},
+		},
+		{
+			"one conflict among clean edits",
+			[]pin.Hunk{replace(0, "a"), replace(4, "bb")},
+			[]pin.Hunk{replace(5, "C"), cut(8, 2)},
+			2,
+			[]wantConflict{{4, 2, "bb", "4C"}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result, err := mergeHunks(c.ours, c.theirs, bytes.NewReader(base), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(result.Hunks) != c.hunks {
+				t.Errorf("got %d merged hunks, expected %d: %+v", len(result.Hunks), c.hunks, result.Hunks)
+			}
+			if len(result.Conflicts) != len(c.conflicts) {
+				t.Fatalf("got %d conflicts, expected %d: %+v", len(result.Conflicts), len(c.conflicts), result.Conflicts)
+			}
+			for i, want := range c.conflicts {
+				got := result.Conflicts[i]
+				if got.Offset != want.offset || got.Length != want.length ||
+					string(got.Ours) != want.ours || string(got.Theirs) != want.theirs {
+					t.Errorf("conflict %d: got %d+%d ours %q theirs %q, expected %d+%d ours %q theirs %q",
+						i, got.Offset, got.Length, got.Ours, got.Theirs,
+						want.offset, want.length, want.ours, want.theirs)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeFiles24Bit(t *testing.T) {
+	format := wavfile.Format{
+		AudioFormat:   wavfile.FormatPCM,
+		Channels:      2,
+		SampleRate:    48000,
+		ByteRate:      48000 * 6,
+		BlockAlign:    6,
+		BitsPerSample: 24,
+	}
+	frame := func(i int) []byte {
+		return []byte{byte(i), byte(i >> 8), 1, byte(i), byte(i >> 8), 2}
+	}
+
+	var base []byte
+	for i := range 200 {
+		base = append(base, frame(i)...)
+	}
+	ours := append([]byte{}, base...)
+	copy(ours[10*6:], bytes.Repeat([]byte{0xaa}, 6*5))
+	theirs := append(append([]byte{}, base...), frame(1000)...)
+
+	dir := t.TempDir()
+	paths := map[string][]byte{"base.wav": base, "ours.wav": ours, "theirs.wav": theirs}
+	for name, data := range paths {
+		testutil.WriteWAV(t, filepath.Join(dir, name), format, data)
+	}
+
+	result, err := MergeFiles(filepath.Join(dir, "base.wav"), filepath.Join(dir, "ours.wav"), filepath.Join(dir, "theirs.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Conflicts) != 0 {
+		t.Fatalf("got %d conflicts, expected none", len(result.Conflicts))
+	}
+	if len(result.Hunks) != 2 {
+		t.Fatalf("got %d hunks, expected one from each side: %+v", len(result.Hunks), result.Hunks)
+	}
+
+	// Both sides replacing the same frames differently conflicts.
+	copy(theirs[12*6:], bytes.Repeat([]byte{0x55}, 6))
+	testutil.WriteWAV(t, filepath.Join(dir, "theirs.wav"), format, theirs)
+
+	result, err = MergeFiles(filepath.Join(dir, "base.wav"), filepath.Join(dir, "ours.wav"), filepath.Join(dir, "theirs.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Conflicts) != 1 {
+		t.Fatalf("got %d conflicts, expected 1", len(result.Conflicts))
+	}
+	if got := result.Conflicts[0]; got.Offset != 10*6 || got.Length != 5*6 {
+		t.Errorf("conflict covers bytes %d+%d, expected %d+%d", got.Offset, got.Length, 10*6, 5*6)
+	}
+}
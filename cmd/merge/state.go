@@ -0,0 +1,208 @@
+package merge
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"stewdio/cmd/pin"
+	"stewdio/internal/codec"
+	"stewdio/internal/wavfile"
+)
+
+// An unfinished merge is kept in a directory next to the merged file,
+// holding the agreed hunks and both sides of every conflict, so it can be
+// resolved later even if OURS was overwritten.
+const stateSuffix = ".merge"
+
+type mergeState struct {
+	Base       string          `json:"base"`
+	File       string          `json:"file"`
+	SourceHash string          `json:"sourceHash"`
+	BitDepth   int             `json:"bitDepth"`
+	Channels   int             `json:"channels"`
+	Float      bool            `json:"float"`
+	Format     wavfile.Format  `json:"format"`
+	Conflicts  []conflictState `json:"conflicts"`
+}
+
+type conflictState struct {
+	Offset int64         `json:"offset"`
+	Length int64         `json:"length"`
+	Start  time.Duration `json:"start"`
+	End    time.Duration `json:"end"`
+}
+
+func StatePath(file string) string {
+	return file + stateSuffix
+}
+
+func HasState(file string) bool {
+	_, err := os.Stat(filepath.Join(StatePath(file), "merge.json"))
+	return err == nil
+}
+
+func ClearState(file string) error {
+	return os.RemoveAll(StatePath(file))
+}
+
+// SaveState records an unresolved merge of basePath into file.
+func SaveState(file string, basePath string, result *Result) error {
+	basePath, err := filepath.Abs(basePath)
+	if err != nil {
+		return err
+	}
+
+	dir := StatePath(file)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	hunksDir := filepath.Join(dir, "hunks")
+	conflictsDir := filepath.Join(dir, "conflicts")
+	for _, d := range []string{hunksDir, conflictsDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return err
+		}
+	}
+
+	for _, hunk := range result.Hunks {
+		if err := pin.WriteHunk(hunksDir, hunk); err != nil {
+			return err
+		}
+	}
+
+	state := mergeState{
+		Base:       basePath,
+		File:       result.template.File,
+		SourceHash: hex.EncodeToString(result.template.SourceHash),
+		BitDepth:   result.template.Format.BitDepth,
+		Channels:   result.template.Format.Channels,
+		Float:      result.template.Format.Float,
+		Format:     result.Format,
+	}
+
+	for i, conflict := range result.Conflicts {
+		state.Conflicts = append(state.Conflicts, conflictState{
+			Offset: conflict.Offset,
+			Length: conflict.Length,
+			Start:  conflict.Start,
+			End:    conflict.End,
+		})
+
+		sides := map[string][]byte{"ours": conflict.Ours, "theirs": conflict.Theirs}
+		for side, data := range sides {
+			payload, err := encodeRegion(data, result.template.Format)
+			if err != nil {
+				return err
+			}
+
+			path := filepath.Join(conflictsDir, fmt.Sprintf("%d_%s.bin", i, side))
+			if err := os.WriteFile(path, payload, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+
+	stateBytes, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, "merge.json"), stateBytes, 0o644)
+}
+
+// LoadState reads back an unresolved merge, returning the base it was
+// made against.
+func LoadState(file string) (string, *Result, error) {
+	dir := StatePath(file)
+
+	stateBytes, err := os.ReadFile(filepath.Join(dir, "merge.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil, fmt.Errorf("no merge in progress for %s", file)
+		}
+		return "", nil, err
+	}
+
+	var state mergeState
+	if err := json.Unmarshal(stateBytes, &state); err != nil {
+		return "", nil, fmt.Errorf("invalid merge state: %w", err)
+	}
+
+	sourceHash, err := hex.DecodeString(state.SourceHash)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid merge state: %w", err)
+	}
+
+	result := &Result{
+		Format: state.Format,
+		template: pin.Hunk{
+			File:       state.File,
+			SourceHash: sourceHash,
+			Format: codec.Header{
+				BitDepth: state.BitDepth,
+				Channels: state.Channels,
+				Float:    state.Float,
+			},
+		},
+	}
+
+	hunksDir := filepath.Join(dir, "hunks")
+	if entries, err := os.ReadDir(hunksDir); err == nil && len(entries) > 0 {
+		result.Hunks, err = pin.ReadPatchSet(hunksDir)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	for i, c := range state.Conflicts {
+		conflict := Conflict{
+			Offset: c.Offset,
+			Length: c.Length,
+			Start:  c.Start,
+			End:    c.End,
+		}
+
+		for _, side := range []string{"ours", "theirs"} {
+			path := filepath.Join(dir, "conflicts", fmt.Sprintf("%d_%s.bin", i, side))
+			payload, err := os.ReadFile(path)
+			if err != nil {
+				return "", nil, err
+			}
+
+			data, err := decodeRegion(payload)
+			if err != nil {
+				return "", nil, fmt.Errorf("%s: %w", path, err)
+			}
+
+			if side == "ours" {
+				conflict.Ours = data
+			} else {
+				conflict.Theirs = data
+			}
+		}
+
+		result.Conflicts = append(result.Conflicts, conflict)
+	}
+
+	return state.Base, result, nil
+}
+
+func encodeRegion(data []byte, format codec.Header) ([]byte, error) {
+	if format.BitDepth == 0 {
+		return data, nil
+	}
+	return codec.Encode(data, format.BitDepth, format.Channels, format.Float)
+}
+
+func decodeRegion(payload []byte) ([]byte, error) {
+	if !codec.IsEncoded(payload) {
+		return payload, nil
+	}
+	data, _, err := codec.Decode(payload)
+	return data, err
+}
@@ -2,6 +2,7 @@ package patchCommand
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 
@@ -12,14 +13,15 @@ import (
 type patchOpts struct {
 	TargetFile string
 	PatchFile  string
+	Backup     bool
 }
 
 func PatchCmd() *cobra.Command {
 	opts := patchOpts{}
 
 	cmd := cobra.Command{
-		Use:   "patch {TARGET_FILE} {PATCH_FILE}",
-		Short: "Apply a patch to a target file",
+		Use:   "patch {TARGET_FILE} {PATCH_FILE | PATCH_DIR}",
+		Short: "Apply a patch or a directory of patches to a target file",
 		Args: func(cmd *cobra.Command, args []string) error {
 			if err := cobra.ExactArgs(2)(cmd, args); err != nil {
 				return err
@@ -36,10 +38,16 @@ func PatchCmd() *cobra.Command {
 		},
 	}
 
+	cmd.AddCommand(SquashCmd())
+
+	cmd.Flags().BoolVarP(&opts.Backup, "backup", "b", false, "Keep a copy of the original file as TARGET_FILE.orig")
+
 	cmd.SetHelpTemplate(cmd.HelpTemplate() + `
 Arguments:
   [TARGET_FILE]   The target file to patch
   [PATCH_FILE]    The patch file to apply
+  [PATCH_DIR]     A directory from "compare"; every patch in it generated
+                  for TARGET_FILE is applied together
 `)
 	cmdUtils.SetHelpFlagText(&cmd)
 
@@ -47,9 +55,24 @@ Arguments:
 }
 
 func patchMain(cmd *cobra.Command, opts *patchOpts) error {
-	err := pin.ApplyPatch(opts.TargetFile, opts.PatchFile)
+	applyOpts := pin.ApplyOptions{
+		Backup: opts.Backup,
+	}
+
+	info, err := os.Stat(opts.PatchFile)
+	if err != nil {
+		fmt.Println("error: failed to apply patch:", err)
+		return err
+	}
+
+	if info.IsDir() {
+		err = pin.ApplyPatchDir(opts.TargetFile, opts.PatchFile, applyOpts)
+	} else {
+		err = pin.ApplyPatch(opts.TargetFile, opts.PatchFile, applyOpts)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to apply patch: %w", err)
+		fmt.Println("error: failed to apply patch:", err)
+		return err
 	}
 	fmt.Println("Patch applied successfully")
 	return nil
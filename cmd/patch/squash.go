@@ -0,0 +1,82 @@
+package patchCommand
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"stewdio/cmd/pin"
+	cmdUtils "stewdio/internal/cmd/utils"
+)
+
+type squashOpts struct {
+	Patches []string
+	Output  string
+}
+
+func SquashCmd() *cobra.Command {
+	opts := squashOpts{}
+
+	cmd := cobra.Command{
+		Use:   "squash {PATCH...} -o {OUTPUT}",
+		Short: "Combine a chain of patches into a single equivalent patch",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if err := cobra.MinimumNArgs(1)(cmd, args); err != nil {
+				return err
+			}
+
+			opts.Patches = args
+
+			return nil
+		},
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdUtils.CommandErrorHandler(squashMain(&opts))
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", "", "Directory to write the combined patch to")
+	_ = cmd.MarkFlagRequired("output")
+
+	cmd.SetHelpTemplate(cmd.HelpTemplate() + `
+Arguments:
+  [PATCH...]   Patch files or compare output directories, oldest first
+`)
+	cmdUtils.SetHelpFlagText(&cmd)
+
+	return &cmd
+}
+
+func squashMain(opts *squashOpts) error {
+	var patches [][]pin.Hunk
+	for _, path := range opts.Patches {
+		hunks, err := pin.ReadPatchSet(path)
+		if err != nil {
+			fmt.Printf("error: failed to read patch %s: %v\n", path, err)
+			return err
+		}
+		patches = append(patches, hunks)
+	}
+
+	squashed, err := pin.SquashPatches(patches...)
+	if err != nil {
+		fmt.Println("error: failed to squash patches:", err)
+		return err
+	}
+
+	if err := os.MkdirAll(opts.Output, 0o755); err != nil {
+		fmt.Println("error: failed to create output directory:", err)
+		return err
+	}
+
+	for _, hunk := range squashed {
+		if err := pin.WriteHunk(opts.Output, hunk); err != nil {
+			fmt.Println("error: failed to write patch:", err)
+			return err
+		}
+	}
+
+	fmt.Printf("Squashed %d patches into %d hunks in %s\n", len(opts.Patches), len(squashed), opts.Output)
+	return nil
+}
@@ -1,70 +1,566 @@
 package pin
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+
+	"stewdio/internal/codec"
+	"stewdio/internal/utils"
+	"stewdio/internal/wavfile"
 )
 
-func ApplyPatch(targetFilePath, patchFilePath string) error {
-	parts := strings.Split(patchFilePath, "_")
-	if len(parts) != 4 {
-		return fmt.Errorf("invalid patch file name format")
+// Patch files start with this magic, a format version byte and the
+// SHA-256 of the file the patch was generated from. Files without it
+// are treated as bare payloads and cannot be verified.
+var patchMagic = []byte("STWP")
+
+const patchFormatVersion = 1
+
+var ErrSourceMismatch = errors.New("target file does not match the patch source")
+
+// Hunk is a single edit to the sample data of a WAV file. Offset and
+// Length are in bytes, relative to the start of the data chunk.
+//
+// Operations are "a" (insert Data at Offset), "s" (cut Length bytes at
+// Offset) and "r" (overwrite Length bytes at Offset with Data).
+type Hunk struct {
+	// Base name of the file the patch was generated from
+	File      string
+	Operation string
+	Offset    int64
+	Length    int64
+	Data      []byte
+	// SHA-256 of the file the hunk was generated against, if known
+	SourceHash []byte
+	// Sample format used to residual-code Data; a zero BitDepth means
+	// the payload is stored raw
+	Format codec.Header
+
+	// Hunks read from a patch file leave Data nil and decode the payload
+	// from path as it is needed. It starts at payloadOffset and decodes
+	// to payloadSize bytes.
+	path          string
+	payloadOffset int64
+	payloadSize   int64
+}
+
+// size returns the length of the hunk's payload in bytes.
+func (h Hunk) size() int64 {
+	if h.path == "" {
+		return int64(len(h.Data))
 	}
+	return h.payloadSize
+}
 
-	operation := parts[1]
-	offsetStr := strings.TrimPrefix(parts[2], "offset")
-	lengthStr := strings.TrimPrefix(parts[3], "len")
-	lengthStr = strings.TrimSuffix(lengthStr, ".bin")
+// openPayload returns a reader for the hunk's payload. A payload in a
+// patch file is decoded block by block as it is read.
+func (h Hunk) openPayload() (io.ReadCloser, error) {
+	if h.path == "" {
+		return io.NopCloser(bytes.NewReader(h.Data)), nil
+	}
 
-	offset, err := strconv.Atoi(offsetStr)
+	f, err := os.Open(h.path)
 	if err != nil {
-		return fmt.Errorf("invalid offset: %v", err)
+		return nil, fmt.Errorf("failed to read patch file: %v", err)
+	}
+	if _, err := f.Seek(h.payloadOffset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to read patch file: %v", err)
+	}
+
+	if h.Format.BitDepth == 0 {
+		return f, nil
 	}
 
-	length, err := strconv.Atoi(lengthStr)
+	payload, err := codec.NewReader(bufio.NewReader(f))
 	if err != nil {
-		return fmt.Errorf("invalid length: %v", err)
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to decode patch file: %v", err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{payload, f}, nil
+}
+
+// readData returns the whole payload of the hunk.
+func (h Hunk) readData() ([]byte, error) {
+	if h.path == "" {
+		return h.Data, nil
 	}
 
-	patchData, err := ioutil.ReadFile(patchFilePath)
+	payload, err := h.openPayload()
 	if err != nil {
-		return fmt.Errorf("failed to read patch file: %v", err)
+		return nil, err
 	}
+	defer func() { _ = payload.Close() }()
 
-	targetFile, err := os.OpenFile(targetFilePath, os.O_RDWR, 0644)
+	data, err := io.ReadAll(payload)
 	if err != nil {
-		return fmt.Errorf("failed to open target file: %v", err)
+		return nil, fmt.Errorf("failed to decode patch file: %v", err)
 	}
-	defer targetFile.Close()
+	return data, nil
+}
 
-	switch operation {
-	case "a":
-		targetData, err := ioutil.ReadAll(targetFile)
+type ApplyOptions struct {
+	// Keep a copy of the unpatched target next to it as TARGET.orig
+	Backup bool
+}
+
+func WritePatchFile(patchFilePath string, sourceHash []byte, payload []byte) error {
+	if len(sourceHash) != sha256.Size {
+		return fmt.Errorf("invalid source hash length: %d", len(sourceHash))
+	}
+
+	data := make([]byte, 0, len(patchMagic)+1+sha256.Size+len(payload))
+	data = append(data, patchMagic...)
+	data = append(data, patchFormatVersion)
+	data = append(data, sourceHash...)
+	data = append(data, payload...)
+
+	return os.WriteFile(patchFilePath, data, 0644)
+}
+
+// WriteHunk stores a hunk in dir as a patch file. The length in the file
+// name is always the size of the raw PCM payload; the file itself holds
+// the residual-coded samples.
+func WriteHunk(dir string, hunk Hunk) error {
+	patchFilePath := filepath.Join(dir, fmt.Sprintf("%s_%s_offset%d_len%d.bin", hunk.File, hunk.Operation, hunk.Offset, hunk.Length))
+
+	payload, err := hunk.readData()
+	if err != nil {
+		return err
+	}
+	if hunk.Format.BitDepth != 0 {
+		encoded, err := codec.Encode(payload, hunk.Format.BitDepth, hunk.Format.Channels, hunk.Format.Float)
+		if err != nil {
+			return fmt.Errorf("failed to encode patch payload: %w", err)
+		}
+		payload = encoded
+	}
+
+	if hunk.SourceHash == nil {
+		return os.WriteFile(patchFilePath, payload, 0644)
+	}
+
+	return WritePatchFile(patchFilePath, hunk.SourceHash, payload)
+}
+
+// ParsePatchFileName reads the operation, offset and length out of a
+// patch file named like "{FILE}_{OP}_offset{N}_len{M}.bin".
+func ParsePatchFileName(patchFilePath string) (Hunk, error) {
+	name := strings.TrimSuffix(filepath.Base(patchFilePath), ".bin")
+
+	// The original file name may itself contain underscores.
+	parts := strings.Split(name, "_")
+	if len(parts) < 4 {
+		return Hunk{}, fmt.Errorf("invalid patch file name format")
+	}
+	fields := parts[len(parts)-3:]
+
+	operation := fields[0]
+	offsetStr := strings.TrimPrefix(fields[1], "offset")
+	lengthStr := strings.TrimPrefix(fields[2], "len")
+
+	offset, err := strconv.ParseInt(offsetStr, 10, 64)
+	if err != nil {
+		return Hunk{}, fmt.Errorf("invalid offset: %v", err)
+	}
+
+	length, err := strconv.ParseInt(lengthStr, 10, 64)
+	if err != nil {
+		return Hunk{}, fmt.Errorf("invalid length: %v", err)
+	}
+
+	return Hunk{
+		File:      strings.Join(parts[:len(parts)-3], "_"),
+		Operation: operation,
+		Offset:    offset,
+		Length:    length,
+	}, nil
+}
+
+// ReadPatch reads the header of a patch file. The payload is left on
+// disk and decoded as the patch is applied.
+func ReadPatch(patchFilePath string) (Hunk, error) {
+	hunk, err := ParsePatchFileName(patchFilePath)
+	if err != nil {
+		return hunk, err
+	}
+
+	f, err := os.Open(patchFilePath)
+	if err != nil {
+		return hunk, fmt.Errorf("failed to read patch file: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return hunk, fmt.Errorf("failed to read patch file: %v", err)
+	}
+
+	r := bufio.NewReader(f)
+	header, err := r.Peek(len(patchMagic) + 1 + sha256.Size)
+	if err != nil && err != io.EOF {
+		return hunk, fmt.Errorf("failed to read patch file: %v", err)
+	}
+
+	if bytes.HasPrefix(header, patchMagic) {
+		if len(header) < len(patchMagic)+1+sha256.Size {
+			return hunk, fmt.Errorf("patch file header is truncated")
+		}
+		if header[len(patchMagic)] != patchFormatVersion {
+			return hunk, fmt.Errorf("unsupported patch format version: %d", header[len(patchMagic)])
+		}
+		hunk.SourceHash = append([]byte{}, header[len(patchMagic)+1:]...)
+		hunk.payloadOffset = int64(len(header))
+		_, _ = r.Discard(len(header))
+	}
+
+	hunk.path = patchFilePath
+	hunk.payloadSize = info.Size() - hunk.payloadOffset
+
+	// Older patches hold raw PCM; newer ones are residual-coded.
+	magic, err := r.Peek(len(codec.Magic))
+	if err != nil && err != io.EOF {
+		return hunk, fmt.Errorf("failed to read patch file: %v", err)
+	}
+	if codec.IsEncoded(magic) {
+		payload, err := codec.NewReader(r)
+		if err != nil {
+			return hunk, fmt.Errorf("failed to decode patch file: %v", err)
+		}
+		hunk.Format = payload.Header()
+		hunk.payloadSize = int64(hunk.Format.Length)
+	}
+
+	return hunk, nil
+}
+
+// ReadPatchDir loads every patch in dir that was generated for
+// targetFilePath: those named after it, or failing that, those whose
+// recorded source hash matches its contents. Only the matching patches
+// are decoded, so unrelated ones in the same directory are never read
+// past their header.
+func ReadPatchDir(dir string, targetFilePath string) ([]Hunk, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patch directory: %v", err)
+	}
+
+	target := filepath.Base(targetFilePath)
+
+	var byName, others []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".bin" {
+			continue
+		}
+		hunk, err := ParsePatchFileName(entry.Name())
 		if err != nil {
-			return fmt.Errorf("failed to read target file: %v", err)
+			continue
 		}
 
-		newData := append(targetData[:offset], append(patchData, targetData[offset:]...)...)
-		if err := ioutil.WriteFile(targetFilePath, newData, 0644); err != nil {
-			return fmt.Errorf("failed to write patched file: %v", err)
+		if hunk.File == target {
+			byName = append(byName, entry.Name())
+		} else {
+			others = append(others, entry.Name())
 		}
+	}
 
-	case "s":
-		targetData, err := ioutil.ReadAll(targetFile)
+	matching := byName
+	if len(matching) == 0 {
+		targetHash, err := utils.HashFile(targetFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash target file: %v", err)
+		}
+
+		for _, name := range others {
+			sourceHash, err := readSourceHash(filepath.Join(dir, name))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			if sourceHash != nil && bytes.Equal(sourceHash, targetHash) {
+				matching = append(matching, name)
+			}
+		}
+	}
+
+	if len(matching) == 0 {
+		return nil, fmt.Errorf("no patches for %s found in %s", target, dir)
+	}
+
+	hunks := make([]Hunk, 0, len(matching))
+	for _, name := range matching {
+		hunk, err := ReadPatch(filepath.Join(dir, name))
 		if err != nil {
-			return fmt.Errorf("failed to read target file: %v", err)
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		hunks = append(hunks, hunk)
+	}
+
+	return hunks, nil
+}
+
+// readSourceHash reads the source hash from the header of a patch file
+// without reading its payload. Bare payloads, and headers from a format
+// this version does not know, have no hash to match and give nil.
+func readSourceHash(patchFilePath string) ([]byte, error) {
+	f, err := os.Open(patchFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patch file: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	header := make([]byte, len(patchMagic)+1+sha256.Size)
+	if _, err := io.ReadFull(f, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
 		}
+		return nil, fmt.Errorf("failed to read patch file: %v", err)
+	}
 
-		newData := append(targetData[:offset], targetData[offset+length:]...)
-		if err := ioutil.WriteFile(targetFilePath, newData, 0644); err != nil {
-			return fmt.Errorf("failed to write patched file: %v", err)
+	if !bytes.HasPrefix(header, patchMagic) || header[len(patchMagic)] != patchFormatVersion {
+		return nil, nil
+	}
+	return header[len(patchMagic)+1:], nil
+}
+
+func ApplyPatch(targetFilePath, patchFilePath string, opts ApplyOptions) error {
+	hunk, err := ReadPatch(patchFilePath)
+	if err != nil {
+		return err
+	}
+
+	return ApplyHunks(targetFilePath, []Hunk{hunk}, opts)
+}
+
+func ApplyPatchDir(targetFilePath, patchDir string, opts ApplyOptions) error {
+	hunks, err := ReadPatchDir(patchDir, targetFilePath)
+	if err != nil {
+		return err
+	}
+
+	return ApplyHunks(targetFilePath, hunks, opts)
+}
+
+// ApplyHunks applies a set of hunks generated against the same source
+// file in a single pass. All offsets refer to the unpatched file, so the
+// order the hunks are given in does not matter. Either every hunk is
+// applied or the target is left untouched.
+func ApplyHunks(targetFilePath string, hunks []Hunk, opts ApplyOptions) error {
+	return WritePatched(targetFilePath, targetFilePath, hunks, opts)
+}
+
+// WritePatched applies hunks to the file at targetFilePath and writes
+// the result to outputFilePath, which may be the same file. Both the
+// target and the hunk payloads are streamed through.
+func WritePatched(targetFilePath string, outputFilePath string, hunks []Hunk, opts ApplyOptions) error {
+	targetFile, err := os.Open(targetFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open target file: %v", err)
+	}
+	defer func() { _ = targetFile.Close() }()
+
+	info, err := targetFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat target file: %v", err)
+	}
+
+	targetHash, err := utils.HashFile(targetFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to hash target file: %v", err)
+	}
+
+	for _, hunk := range hunks {
+		if err := verifySource(hunk.SourceHash, targetHash); err != nil {
+			return err
+		}
+	}
+
+	wav, err := wavfile.Parse(targetFile, info.Size())
+	if err != nil {
+		return fmt.Errorf("failed to parse target file: %v", err)
+	}
+
+	for _, hunk := range hunks {
+		if err := validateHunk(hunk, wav); err != nil {
+			return err
+		}
+	}
+
+	hunks, err = orderHunks(hunks)
+	if err != nil {
+		return err
+	}
+
+	dataChunk := wav.Data()
+
+	newDataSize := dataChunk.Size
+	for _, hunk := range hunks {
+		switch hunk.Operation {
+		case "a":
+			newDataSize += hunk.Length
+		case "s":
+			newDataSize -= hunk.Length
+		}
+	}
+
+	// Copy the untouched stretches of sample data straight from the
+	// source file rather than reading it into memory.
+	writeSamples := func(w io.Writer) error {
+		copySamples := func(from, to int64) error {
+			_, err := io.Copy(w, io.NewSectionReader(targetFile, dataChunk.DataOffset()+from, to-from))
+			return err
+		}
+
+		cursor := int64(0)
+		for _, hunk := range hunks {
+			if err := copySamples(cursor, hunk.Offset); err != nil {
+				return err
+			}
+
+			switch hunk.Operation {
+			case "a":
+				cursor = hunk.Offset
+			case "s", "r":
+				cursor = hunk.Offset + hunk.Length
+			}
+
+			if hunk.Operation != "s" {
+				if err := copyPayload(w, hunk); err != nil {
+					return err
+				}
+			}
+		}
+
+		return copySamples(cursor, dataChunk.Size)
+	}
+
+	if opts.Backup && utils.PathExists(outputFilePath) {
+		if err := utils.CopyFile(outputFilePath, outputFilePath+".orig"); err != nil {
+			return fmt.Errorf("failed to back up target file: %v", err)
+		}
+	}
+
+	err = utils.WriteFileAtomic(outputFilePath, info.Mode().Perm(), func(out io.Writer) error {
+		bw := bufio.NewWriter(out)
+		if err := wav.Rewrite(bw, targetFile, newDataSize, writeSamples); err != nil {
+			return err
+		}
+		return bw.Flush()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write patched file: %v", err)
+	}
+
+	return nil
+}
+
+// copyPayload writes the payload of hunk to w.
+func copyPayload(w io.Writer, hunk Hunk) error {
+	payload, err := hunk.openPayload()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = payload.Close() }()
+
+	n, err := io.Copy(w, payload)
+	if err != nil {
+		return fmt.Errorf("failed to decode patch file: %v", err)
+	}
+	if n != hunk.size() {
+		return fmt.Errorf("patch payload is %d bytes, expected %d", n, hunk.size())
+	}
+	return nil
+}
+
+// orderHunks sorts hunks by offset. At the same offset an insertion goes
+// before a cut or replace, so new samples land in front of that region.
+// Hunks that touch the same samples cannot be applied together.
+func orderHunks(hunks []Hunk) ([]Hunk, error) {
+	sorted := append([]Hunk{}, hunks...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Offset != sorted[j].Offset {
+			return sorted[i].Offset < sorted[j].Offset
+		}
+		return sorted[i].Operation == "a" && sorted[j].Operation != "a"
+	})
+
+	cursor := int64(0)
+	for _, hunk := range sorted {
+		if hunk.Offset < cursor {
+			return nil, fmt.Errorf("patch at offset %d overlaps a previous patch ending at %d", hunk.Offset, cursor)
+		}
+		if hunk.Operation != "a" {
+			cursor = hunk.Offset + hunk.Length
+		}
+	}
+
+	return sorted, nil
+}
+
+func verifySource(expected []byte, actual []byte) error {
+	if expected == nil {
+		return nil
+	}
+
+	if !bytes.Equal(expected, actual) {
+		return fmt.Errorf("%w: expected sha256 %s, got %s", ErrSourceMismatch, hex.EncodeToString(expected), hex.EncodeToString(actual))
+	}
+
+	return nil
+}
+
+// validateHunk makes sure a hunk lands on whole frames inside the data
+// chunk of the target, so applying it cannot misalign the channels.
+func validateHunk(hunk Hunk, wav *wavfile.File) error {
+	blockAlign := int64(wav.Format.BlockAlign)
+	dataSize := wav.Data().Size
+
+	if hunk.Offset < 0 || hunk.Length < 0 {
+		return fmt.Errorf("invalid patch: negative offset or length")
+	}
+	if hunk.Offset%blockAlign != 0 {
+		return fmt.Errorf("patch offset %d is not aligned to %d-byte frames", hunk.Offset, blockAlign)
+	}
+	if hunk.Length%blockAlign != 0 {
+		return fmt.Errorf("patch length %d is not a whole number of %d-byte frames", hunk.Length, blockAlign)
+	}
+
+	switch hunk.Operation {
+	case "a":
+		if hunk.size() != hunk.Length {
+			return fmt.Errorf("patch payload is %d bytes, expected %d", hunk.size(), hunk.Length)
+		}
+		if hunk.Offset > dataSize {
+			return fmt.Errorf("patch offset %d is past the end of the audio data (%d bytes)", hunk.Offset, dataSize)
+		}
+
+	case "s":
+		if hunk.Offset+hunk.Length > dataSize {
+			return fmt.Errorf("patch removes bytes %d-%d, past the end of the audio data (%d bytes)", hunk.Offset, hunk.Offset+hunk.Length, dataSize)
+		}
+
+	case "r":
+		if hunk.size() != hunk.Length {
+			return fmt.Errorf("patch payload is %d bytes, expected %d", hunk.size(), hunk.Length)
+		}
+		if hunk.Offset+hunk.Length > dataSize {
+			return fmt.Errorf("patch replaces bytes %d-%d, past the end of the audio data (%d bytes)", hunk.Offset, hunk.Offset+hunk.Length, dataSize)
 		}
 
 	default:
-		return fmt.Errorf("invalid operation: %s", operation)
+		return fmt.Errorf("invalid operation: %s", hunk.Operation)
 	}
 
 	return nil
@@ -0,0 +1,99 @@
+package pin
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"stewdio/internal/utils"
+)
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadPatchDir(t *testing.T) {
+	t.Run("by name ignores unrelated patches", func(t *testing.T) {
+		dir := t.TempDir()
+		target := filepath.Join(t.TempDir(), "drums.wav")
+		writeTestFile(t, target, []byte("target"))
+
+		hunk := Hunk{File: "drums.wav", Operation: "a", Offset: 4, Length: 4, Data: []byte{1, 2, 3, 4}}
+		if err := WriteHunk(dir, hunk); err != nil {
+			t.Fatal(err)
+		}
+		// A corrupt patch for another file must not get in the way.
+		writeTestFile(t, filepath.Join(dir, "bass.wav_a_offset0_len8.bin"), []byte("STWCgarbage"))
+
+		hunks, err := ReadPatchDir(dir, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hunks) != 1 || hunks[0].File != "drums.wav" {
+			t.Fatalf("got %+v, expected the drums.wav patch", hunks)
+		}
+		// The payload stays on disk until it is needed.
+		if hunks[0].Data != nil {
+			t.Errorf("payload was read along with the header")
+		}
+		if data, err := hunks[0].readData(); err != nil || !bytes.Equal(data, hunk.Data) {
+			t.Errorf("got payload %v, %v, expected %v", data, err, hunk.Data)
+		}
+	})
+
+	t.Run("by source hash ignores unrelated patches", func(t *testing.T) {
+		dir := t.TempDir()
+		target := filepath.Join(t.TempDir(), "renamed.wav")
+		writeTestFile(t, target, []byte("target"))
+		targetHash, err := utils.HashFile(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hunk := Hunk{File: "drums.wav", Operation: "s", Offset: 0, Length: 4, SourceHash: targetHash}
+		if err := WriteHunk(dir, hunk); err != nil {
+			t.Fatal(err)
+		}
+
+		otherHash := bytes.Repeat([]byte{0xaa}, len(targetHash))
+		corrupt := append(append(append([]byte{}, patchMagic...), patchFormatVersion), otherHash...)
+		corrupt = append(corrupt, "STWCgarbage"...)
+		writeTestFile(t, filepath.Join(dir, "bass.wav_r_offset0_len8.bin"), corrupt)
+		// Too short to have a header at all
+		writeTestFile(t, filepath.Join(dir, "keys.wav_s_offset0_len8.bin"), []byte("ST"))
+
+		hunks, err := ReadPatchDir(dir, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hunks) != 1 || hunks[0].Operation != "s" || hunks[0].File != "drums.wav" {
+			t.Fatalf("got %+v, expected the drums.wav patch", hunks)
+		}
+	})
+
+	t.Run("corrupt matching patch fails", func(t *testing.T) {
+		dir := t.TempDir()
+		target := filepath.Join(t.TempDir(), "drums.wav")
+		writeTestFile(t, target, []byte("target"))
+		writeTestFile(t, filepath.Join(dir, "drums.wav_a_offset0_len8.bin"), []byte("STWCgarbage"))
+
+		if _, err := ReadPatchDir(dir, target); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("no matching patches", func(t *testing.T) {
+		dir := t.TempDir()
+		target := filepath.Join(t.TempDir(), "drums.wav")
+		writeTestFile(t, target, []byte("target"))
+		writeTestFile(t, filepath.Join(dir, "bass.wav_a_offset0_len4.bin"), []byte{1, 2, 3, 4})
+
+		if _, err := ReadPatchDir(dir, target); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
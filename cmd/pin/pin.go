@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -63,7 +64,8 @@ func pinMain(opts *PinOpts) error {
 
 	fmt.Println("Pinning current project...")
 
-	version := refs.ReadVersion(cwd)
+	parent := refs.ReadVersion(cwd)
+	version := parent
 	version.Minor++
 
 	refs.WriteVersion(cwd, version)
@@ -72,7 +74,7 @@ func pinMain(opts *PinOpts) error {
 
 	diffs := computeDiffs(snapshot, version)
 
-	storeSnapshotAndDiffs(version, snapshot, diffs, opts.Message)
+	storeSnapshotAndDiffs(version, parent, snapshot, diffs, opts.Message)
 
 	fmt.Println("Project pinned to version", version)
 
@@ -83,7 +85,17 @@ func pinMain(opts *PinOpts) error {
 		return err
 	}
 
-	err = pin_utils.Push(cwd, cfg.Remote, version.String())
+	err = pin_utils.Push(cwd, cfg.Remote, version.String(), parent.String())
+	var conflict *pin_utils.NotFastForwardError
+	if errors.As(err, &conflict) {
+		// Undo the pin so it can be made again on top of the remote's.
+		refs.WriteVersion(cwd, parent)
+		_ = os.RemoveAll(filepath.Join(cwd, ".stew", "objects", version.String()))
+
+		fmt.Printf("error: %v, so pin %v was not made\n", err, version)
+		fmt.Printf("run \"stewdio checkout %s\" and pin again\n", conflict.Latest)
+		return err
+	}
 	if err != nil {
 		fmt.Printf("error pushing pin %v: %v\n", version.String(), err)
 		fmt.Println("unable to push pin, the repo is fucked")
@@ -156,7 +168,7 @@ func readPreviousSnapshot(version refs.Version) map[string]bool {
 	return refs
 }
 
-func storeSnapshotAndDiffs(version refs.Version, snapshot map[string]bool, diffs []refs.Diff, message string) {
+func storeSnapshotAndDiffs(version refs.Version, parent refs.Version, snapshot map[string]bool, diffs []refs.Diff, message string) {
 	dir := fmt.Sprintf(".stew/objects/%d.%d", version.Major, version.Minor)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		panic(err)
@@ -180,6 +192,7 @@ func storeSnapshotAndDiffs(version refs.Version, snapshot map[string]bool, diffs
 	}
 
 	tar_utils.AddStringToTar(tarWriter, "message", message)
+	tar_utils.AddStringToTar(tarWriter, "parent", parent.String())
 
 	// 2. Write diffs.json
 	diffBytes, err := json.MarshalIndent(diffs, "", "  ")
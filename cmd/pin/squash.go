@@ -0,0 +1,291 @@
+package pin
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// openEnd marks a copy that runs to the end of the source data, whose
+// length a patch does not record.
+const openEnd int64 = math.MaxInt64
+
+// segment is one piece of a patched file's sample data: either a range
+// of the source data or literal bytes from a hunk.
+type segment struct {
+	literal   []byte
+	isLiteral bool
+	from, to  int64
+}
+
+func (s segment) length() int64 {
+	if s.isLiteral {
+		return int64(len(s.literal))
+	}
+	if s.to == openEnd {
+		return openEnd
+	}
+	return s.to - s.from
+}
+
+// ReadPatchSet loads a single patch file, or every patch in a directory
+// written by one compare run.
+func ReadPatchSet(path string) ([]Hunk, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		hunk, err := ReadPatch(path)
+		if err != nil {
+			return nil, err
+		}
+		return []Hunk{hunk}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patch directory: %v", err)
+	}
+
+	var hunks []Hunk
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".bin" {
+			continue
+		}
+
+		hunk, err := ReadPatch(filepath.Join(path, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+
+		if len(hunks) > 0 && hunk.File != hunks[0].File {
+			return nil, fmt.Errorf("%s contains patches for both %s and %s", path, hunks[0].File, hunk.File)
+		}
+		hunks = append(hunks, hunk)
+	}
+
+	if len(hunks) == 0 {
+		return nil, fmt.Errorf("no patches found in %s", path)
+	}
+
+	return hunks, nil
+}
+
+// SquashPatches folds a chain of patches, each generated against the
+// result of the one before it, into a single patch that takes the first
+// patch's source straight to the final result.
+func SquashPatches(patches ...[]Hunk) ([]Hunk, error) {
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no patches to squash")
+	}
+
+	squashed := patches[0]
+	for _, next := range patches[1:] {
+		composed, err := ComposeHunks(squashed, next)
+		if err != nil {
+			return nil, err
+		}
+		squashed = composed
+	}
+
+	return squashed, nil
+}
+
+// ComposeHunks returns hunks equivalent to applying first and then
+// second. Offsets in second refer to the output of first; offsets in
+// the result refer to the source of first, like first's own.
+func ComposeHunks(first, second []Hunk) ([]Hunk, error) {
+	firstSegments, err := hunksToSegments(first)
+	if err != nil {
+		return nil, err
+	}
+
+	secondSegments, err := hunksToSegments(second)
+	if err != nil {
+		return nil, err
+	}
+
+	var composed []segment
+	for _, seg := range secondSegments {
+		if seg.isLiteral {
+			composed = append(composed, seg)
+			continue
+		}
+		composed = append(composed, mapRange(firstSegments, seg.from, seg.to)...)
+	}
+
+	hunks, err := segmentsToHunks(composed)
+	if err != nil {
+		return nil, err
+	}
+
+	template := Hunk{}
+	if len(first) > 0 {
+		template = first[0]
+	}
+
+	format := template.Format
+	for _, hunk := range append(append([]Hunk{}, first...), second...) {
+		if hunk.Format.BitDepth != 0 {
+			format = hunk.Format
+			break
+		}
+	}
+
+	for i := range hunks {
+		hunks[i].File = template.File
+		hunks[i].SourceHash = template.SourceHash
+		hunks[i].Format = format
+	}
+
+	return hunks, nil
+}
+
+func hunksToSegments(hunks []Hunk) ([]segment, error) {
+	ordered, err := orderHunks(hunks)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []segment
+	cursor := int64(0)
+	for _, hunk := range ordered {
+		data, err := hunk.readData()
+		if err != nil {
+			return nil, err
+		}
+
+		if hunk.Offset > cursor {
+			segments = append(segments, segment{from: cursor, to: hunk.Offset})
+		}
+
+		switch hunk.Operation {
+		case "a":
+			cursor = hunk.Offset
+			segments = append(segments, segment{literal: data, isLiteral: true})
+		case "s":
+			cursor = hunk.Offset + hunk.Length
+		case "r":
+			if int64(len(data)) != hunk.Length {
+				return nil, fmt.Errorf("patch payload is %d bytes, expected %d", len(data), hunk.Length)
+			}
+			cursor = hunk.Offset + hunk.Length
+			segments = append(segments, segment{literal: data, isLiteral: true})
+		default:
+			return nil, fmt.Errorf("invalid operation: %s", hunk.Operation)
+		}
+	}
+	segments = append(segments, segment{from: cursor, to: openEnd})
+
+	return segments, nil
+}
+
+// mapRange translates the output range [from, to) of a patch back into
+// the segments of the patch that produced it.
+func mapRange(segments []segment, from, to int64) []segment {
+	var mapped []segment
+
+	pos := int64(0)
+	for _, seg := range segments {
+		if pos >= to {
+			break
+		}
+
+		end := openEnd
+		if seg.length() != openEnd {
+			end = pos + seg.length()
+		}
+
+		lo, hi := max(from, pos), min(to, end)
+		if lo < hi {
+			if seg.isLiteral {
+				mapped = append(mapped, segment{literal: seg.literal[lo-pos : hi-pos], isLiteral: true})
+			} else {
+				copyTo := openEnd
+				if hi != openEnd {
+					copyTo = seg.from + (hi - pos)
+				}
+				mapped = append(mapped, segment{from: seg.from + (lo - pos), to: copyTo})
+			}
+		}
+
+		pos = end
+	}
+
+	return mapped
+}
+
+// segmentsToHunks turns an edit script back into hunks against its
+// source.
+func segmentsToHunks(segments []segment) ([]Hunk, error) {
+	var hunks []Hunk
+
+	cursor := int64(0)
+	var pending []byte
+
+	emit := func(gapEnd int64) {
+		hunks = append(hunks, ReplaceRange(cursor, gapEnd-cursor, pending)...)
+		pending = nil
+	}
+
+	for _, seg := range segments {
+		if seg.isLiteral {
+			pending = append(pending, seg.literal...)
+			continue
+		}
+		if seg.from == seg.to {
+			continue
+		}
+		if seg.from < cursor {
+			return nil, fmt.Errorf("patches reorder source data at offset %d", seg.from)
+		}
+
+		emit(seg.from)
+		cursor = seg.to
+	}
+
+	if pending != nil || cursor != openEnd {
+		return nil, fmt.Errorf("patches do not end with the rest of the source data")
+	}
+
+	return hunks, nil
+}
+
+// ReplaceRange returns the hunks that swap length bytes of source data
+// at offset for data. The overlap is written as a replace; whatever is
+// left over becomes an insertion or a cut.
+func ReplaceRange(offset int64, length int64, data []byte) []Hunk {
+	var hunks []Hunk
+
+	size := int64(len(data))
+	overlap := min(length, size)
+
+	if overlap > 0 {
+		hunks = append(hunks, Hunk{
+			Operation: "r",
+			Offset:    offset,
+			Length:    overlap,
+			Data:      data[:overlap],
+		})
+	}
+	if size > overlap {
+		hunks = append(hunks, Hunk{
+			Operation: "a",
+			Offset:    offset + length,
+			Length:    size - overlap,
+			Data:      data[overlap:],
+		})
+	}
+	if length > overlap {
+		hunks = append(hunks, Hunk{
+			Operation: "s",
+			Offset:    offset + overlap,
+			Length:    length - overlap,
+		})
+	}
+
+	return hunks
+}
@@ -0,0 +1,161 @@
+package pin
+
+import (
+	"bytes"
+	"testing"
+)
+
+// applyBytes applies hunks to in-memory sample data, the way WritePatched
+// does to a file.
+func applyBytes(t *testing.T, src []byte, hunks []Hunk) []byte {
+	t.Helper()
+	ordered, err := orderHunks(hunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out []byte
+	cursor := int64(0)
+	for _, hunk := range ordered {
+		out = append(out, src[cursor:hunk.Offset]...)
+		switch hunk.Operation {
+		case "a":
+			cursor = hunk.Offset
+			out = append(out, hunk.Data...)
+		case "s":
+			cursor = hunk.Offset + hunk.Length
+		case "r":
+			cursor = hunk.Offset + hunk.Length
+			out = append(out, hunk.Data...)
+		}
+	}
+	return append(out, src[cursor:]...)
+}
+
+func insert(offset int64, data string) Hunk {
+	return Hunk{Operation: "a", Offset: offset, Length: int64(len(data)), Data: []byte(data)}
+}
+
+func cut(offset int64, length int64) Hunk {
+	return Hunk{Operation: "s", Offset: offset, Length: length}
+}
+
+func replace(offset int64, data string) Hunk {
+	return Hunk{Operation: "r", Offset: offset, Length: int64(len(data)), Data: []byte(data)}
+}
+
+func TestComposeHunks(t *testing.T) {
+	src := []byte("0123456789")
+
+	cases := []struct {
+		name   string
+		first  []Hunk
+		second []Hunk
+		// Expected result of applying both, checked against the
+		// composed hunks
+		want string
+		// Expected number of composed hunks, or -1 to not check
+		hunks int
+	}{
+		{"nothing", nil, nil, "0123456789", 0},
+		{"first only", []Hunk{insert(2, "ab")}, nil, "01ab23456789", 1},
+		{"second only", nil, []Hunk{cut(0, 3)}, "3456789", 1},
+		{"disjoint", []Hunk{replace(1, "x")}, []Hunk{cut(8, 2)}, "0x234567", 2},
+		{"edit inside an insert", []Hunk{insert(5, "abcd")}, []Hunk{replace(6, "XY")}, "01234aXYd56789", 1},
+		{"cut what was inserted", []Hunk{insert(5, "abc")}, []Hunk{cut(5, 3)}, "0123456789", 0},
+		{"cut across an insert", []Hunk{insert(5, "abc")}, []Hunk{cut(3, 4)}, "012c56789", -1},
+		{"replace across a cut", []Hunk{cut(3, 3)}, []Hunk{replace(2, "XY")}, "01XY789", -1},
+		{"insert at the start", []Hunk{replace(0, "x")}, []Hunk{insert(0, "ab")}, "abx123456789", -1},
+		{"append at the end", []Hunk{cut(8, 2)}, []Hunk{insert(8, "end")}, "01234567end", -1},
+		{"replace then restore", []Hunk{replace(4, "xx")}, []Hunk{replace(4, "45")}, "0123456789", -1},
+		{
+			"several of each",
+			[]Hunk{insert(0, "a"), cut(2, 2), replace(6, "zz")},
+			[]Hunk{cut(0, 2), insert(4, "q"), replace(7, "!")},
+			"14q5zz!9", -1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sequential := applyBytes(t, applyBytes(t, src, c.first), c.second)
+			if string(sequential) != c.want {
+				t.Fatalf("applying in turn gave %q, expected %q", sequential, c.want)
+			}
+
+			composed, err := ComposeHunks(c.first, c.second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := applyBytes(t, src, composed); !bytes.Equal(got, sequential) {
+				t.Errorf("composed hunks give %q, expected %q", got, sequential)
+			}
+			if c.hunks >= 0 && len(composed) != c.hunks {
+				t.Errorf("got %d hunks, expected %d: %+v", len(composed), c.hunks, composed)
+			}
+		})
+	}
+}
+
+func TestComposeHunksKeepsSource(t *testing.T) {
+	first := []Hunk{insert(0, "a")}
+	first[0].File = "take.wav"
+	first[0].SourceHash = bytes.Repeat([]byte{1}, 32)
+	first[0].Format.BitDepth = 16
+	first[0].Format.Channels = 1
+
+	composed, err := ComposeHunks(first, []Hunk{cut(4, 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hunk := range composed {
+		if hunk.File != "take.wav" || !bytes.Equal(hunk.SourceHash, first[0].SourceHash) || hunk.Format != first[0].Format {
+			t.Errorf("hunk %+v lost the source of the first patch", hunk)
+		}
+	}
+}
+
+func TestComposeHunksErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		first  []Hunk
+		second []Hunk
+	}{
+		{"overlapping first", []Hunk{cut(0, 4), replace(2, "xx")}, nil},
+		{"overlapping second", nil, []Hunk{replace(0, "abc"), cut(1, 1)}},
+		{"invalid operation", []Hunk{{Operation: "x", Offset: 0}}, nil},
+		{"short replace payload", nil, []Hunk{{Operation: "r", Offset: 0, Length: 4, Data: []byte("ab")}}},
+	}
+
+	for _, c := range cases {
+		if _, err := ComposeHunks(c.first, c.second); err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+}
+
+func TestSquashPatches(t *testing.T) {
+	src := []byte("0123456789")
+	chain := [][]Hunk{
+		{insert(10, "ab")},
+		{replace(0, "x"), cut(5, 2)},
+		{insert(3, "-"), cut(8, 1)},
+	}
+
+	want := src
+	for _, hunks := range chain {
+		want = applyBytes(t, want, hunks)
+	}
+
+	squashed, err := SquashPatches(chain...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := applyBytes(t, src, squashed); !bytes.Equal(got, want) {
+		t.Errorf("squashed patch gives %q, expected %q", got, want)
+	}
+
+	if _, err := SquashPatches(); err == nil {
+		t.Error("squashing nothing: expected an error")
+	}
+}
@@ -0,0 +1,203 @@
+package resolve
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"stewdio/cmd/merge"
+	"stewdio/cmd/pin"
+	cmdUtils "stewdio/internal/cmd/utils"
+	"stewdio/internal/codec"
+	"stewdio/internal/wavfile"
+)
+
+const (
+	strategyOurs      = "ours"
+	strategyTheirs    = "theirs"
+	strategyCrossfade = "crossfade"
+)
+
+type resolveOpts struct {
+	File     string
+	Strategy string
+}
+
+func ResolveCmd() *cobra.Command {
+	opts := resolveOpts{}
+
+	cmd := cobra.Command{
+		Use:   "resolve {FILE}",
+		Short: "Resolve conflicting regions left by a merge",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if err := cobra.ExactArgs(1)(cmd, args); err != nil {
+				return err
+			}
+
+			opts.File = args[0]
+
+			switch opts.Strategy {
+			case "", strategyOurs, strategyTheirs, strategyCrossfade:
+			default:
+				return fmt.Errorf("invalid strategy %q, expected ours, theirs or crossfade", opts.Strategy)
+			}
+
+			return nil
+		},
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdUtils.CommandErrorHandler(resolveMain(cmd, &opts))
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.Strategy, "strategy", "s", "", "Resolve every region the same way: ours, theirs or crossfade")
+
+	cmd.SetHelpTemplate(cmd.HelpTemplate() + `
+Arguments:
+  [FILE]   The merged file with conflicts
+
+Without --strategy, each conflicting region is listed and you are asked
+to keep ours, theirs, or crossfade from ours into theirs.
+`)
+	cmdUtils.SetHelpFlagText(&cmd)
+
+	return &cmd
+}
+
+func resolveMain(cmd *cobra.Command, opts *resolveOpts) error {
+	base, result, err := merge.LoadState(opts.File)
+	if err != nil {
+		fmt.Println("error:", err)
+		return err
+	}
+
+	fmt.Printf("%d conflicting regions in %s:\n", len(result.Conflicts), opts.File)
+	for i, conflict := range result.Conflicts {
+		fmt.Printf("  %d: %s - %s\n", i+1, merge.FormatTimestamp(conflict.Start), merge.FormatTimestamp(conflict.End))
+	}
+
+	in := bufio.NewReader(cmd.InOrStdin())
+
+	hunks := append([]pin.Hunk{}, result.Hunks...)
+	for i, conflict := range result.Conflicts {
+		strategy := opts.Strategy
+		if strategy == "" {
+			strategy, err = promptStrategy(in, i+1, conflict)
+			if err != nil {
+				fmt.Println("error:", err)
+				return err
+			}
+		}
+
+		var data []byte
+		switch strategy {
+		case strategyOurs:
+			data = conflict.Ours
+		case strategyTheirs:
+			data = conflict.Theirs
+		case strategyCrossfade:
+			data, err = crossfade(conflict.Ours, conflict.Theirs, result.Format)
+			if err != nil {
+				fmt.Println("error:", err)
+				return err
+			}
+		}
+
+		hunks = append(hunks, result.ConflictHunks(conflict, data)...)
+	}
+
+	if err := pin.WritePatched(base, opts.File, hunks, pin.ApplyOptions{}); err != nil {
+		fmt.Println("error: failed to write resolved file:", err)
+		return err
+	}
+
+	if err := merge.ClearState(opts.File); err != nil {
+		fmt.Println("error: failed to clear merge state:", err)
+		return err
+	}
+
+	fmt.Println("Resolved all conflicts in", opts.File)
+	return nil
+}
+
+func promptStrategy(in *bufio.Reader, index int, conflict merge.Conflict) (string, error) {
+	for {
+		fmt.Printf("Region %d (%s - %s): [o]urs, [t]heirs or [c]rossfade? ", index,
+			merge.FormatTimestamp(conflict.Start), merge.FormatTimestamp(conflict.End))
+
+		line, err := in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", fmt.Errorf("no choice given for region %d", index)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "o", strategyOurs:
+			return strategyOurs, nil
+		case "t", strategyTheirs:
+			return strategyTheirs, nil
+		case "c", strategyCrossfade:
+			return strategyCrossfade, nil
+		}
+
+		fmt.Println("Please answer o, t or c.")
+	}
+}
+
+// crossfade blends linearly from our version of a region into theirs.
+// If one side is shorter it is padded with silence.
+func crossfade(ours, theirs []byte, wav wavfile.Format) ([]byte, error) {
+	format := codec.Header{
+		BitDepth: int(wav.BitsPerSample),
+		Channels: int(wav.Channels),
+		Float:    wav.AudioFormat == wavfile.FormatFloat,
+	}
+
+	// Frames may be padded past their samples, so step by the block
+	// alignment rather than the sample size.
+	sampleBytes := format.BitDepth / 8
+	frameBytes := int(wav.BlockAlign)
+	if frameBytes == 0 || format.Channels == 0 || sampleBytes*format.Channels > frameBytes {
+		return nil, fmt.Errorf("%w: block alignment too small", wavfile.ErrUnsupportedFormat)
+	}
+	if len(ours)%frameBytes != 0 || len(theirs)%frameBytes != 0 {
+		return nil, fmt.Errorf("cannot crossfade regions that are not whole frames")
+	}
+
+	frames := max(len(ours), len(theirs)) / frameBytes
+	out := make([]byte, frames*frameBytes)
+
+	sample := func(data []byte, off int) float64 {
+		if off >= len(data) {
+			return 0
+		}
+		v := codec.UnpackSample(data[off:], format.BitDepth)
+		if format.Float {
+			return float64(math.Float32frombits(uint32(v)))
+		}
+		return float64(v)
+	}
+
+	for frame := range frames {
+		t := 0.0
+		if frames > 1 {
+			t = float64(frame) / float64(frames-1)
+		}
+
+		for ch := range format.Channels {
+			off := frame*frameBytes + ch*sampleBytes
+			mixed := sample(ours, off)*(1-t) + sample(theirs, off)*t
+
+			if format.Float {
+				codec.PackSample(out[off:], int64(math.Float32bits(float32(mixed))), format.BitDepth)
+			} else {
+				codec.PackSample(out[off:], int64(math.Round(mixed)), format.BitDepth)
+			}
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,36 @@
+package resolve
+
+import (
+	"bytes"
+	"testing"
+
+	"stewdio/internal/wavfile"
+)
+
+func TestCrossfadePaddedFrames(t *testing.T) {
+	// 24-bit mono stored in 4-byte frames, the last byte padding
+	format := wavfile.Format{
+		AudioFormat:   wavfile.FormatPCM,
+		Channels:      1,
+		SampleRate:    1000,
+		ByteRate:      4000,
+		BlockAlign:    4,
+		BitsPerSample: 24,
+	}
+	ours := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	theirs := []byte{0x10, 0, 0, 0, 0x10, 0, 0, 0, 0x10, 0, 0, 0}
+
+	out, err := crossfade(ours, theirs, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0, 0, 0, 0, 0x08, 0, 0, 0, 0x10, 0, 0, 0}
+	if !bytes.Equal(out, expected) {
+		t.Errorf("got %v, expected %v", out, expected)
+	}
+
+	format.BlockAlign = 2
+	if _, err := crossfade(ours, theirs, format); err == nil {
+		t.Error("expected an error for frames smaller than their samples")
+	}
+}
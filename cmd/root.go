@@ -6,9 +6,14 @@ import (
 	"stewdio/cmd/checkout"
 	"stewdio/cmd/compare"
 	"stewdio/cmd/init"
+	"stewdio/cmd/login"
+	"stewdio/cmd/member"
+	"stewdio/cmd/merge"
 	patchCommand "stewdio/cmd/patch"
 	"stewdio/cmd/pin"
+	"stewdio/cmd/resolve"
 	"stewdio/cmd/server"
+	"stewdio/cmd/watch"
 
 	"github.com/spf13/cobra"
 )
@@ -32,10 +37,15 @@ func MainCommand() *cobra.Command {
 
 	cmd.AddCommand(checkout.CheckoutCmd())
 	cmd.AddCommand(init_cmd.InitCommand())
+	cmd.AddCommand(login.LoginCmd())
+	cmd.AddCommand(member.MemberCmd())
 	cmd.AddCommand(pin.PinCommand())
 	cmd.AddCommand(server.ServerCommand())
 	cmd.AddCommand(compare.CompareCmd())
 	cmd.AddCommand(patchCommand.PatchCmd())
+	cmd.AddCommand(merge.MergeCmd())
+	cmd.AddCommand(resolve.ResolveCmd())
+	cmd.AddCommand(watch.WatchRemoteCmd())
 
 	return &cmd
 }
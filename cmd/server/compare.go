@@ -0,0 +1,275 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+
+	"stewdio/cmd/compare"
+	pinCmd "stewdio/cmd/pin"
+	"stewdio/internal/index"
+	"stewdio/internal/store"
+	"stewdio/internal/validate"
+	"stewdio/internal/wavfile"
+)
+
+type compareRes struct {
+	From     string        `json:"from"`
+	To       string        `json:"to"`
+	Added    []string      `json:"added"`
+	Removed  []string      `json:"removed"`
+	Modified []modifiedRes `json:"modified"`
+}
+
+type modifiedRes struct {
+	File string `json:"file"`
+	// Changed stretches of audio, empty if only something other than
+	// the audio changed or the audio could not be compared
+	Regions []regionRes `json:"regions"`
+	// Why the audio could not be compared, if it could not
+	Reason string `json:"reason,omitempty"`
+}
+
+// regionRes is a changed stretch of audio. Times are in seconds from the
+// start of the old file; inserted audio starts at its insertion point
+// and runs for as long as the new audio does.
+type regionRes struct {
+	Type  string  `json:"type"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+var hunkTypes = map[string]string{
+	"a": "inserted",
+	"s": "cut",
+	"r": "replaced",
+}
+
+// HandleCompare lists the files added, removed and modified between two
+// versions, and where modified audio changed.
+func (s *Server) HandleCompare(w http.ResponseWriter, r *http.Request) {
+	project := chi.URLParam(r, "project")
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+
+	if from == "" || to == "" {
+		http.Error(w, "Missing from or to parameter", http.StatusBadRequest)
+		return
+	}
+	for _, version := range []string{from, to} {
+		if err := validate.Version(version); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	res, err := s.compareVersions(project, from, to)
+	if err == nil {
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Version not found", http.StatusNotFound)
+	} else {
+		fmt.Printf("error comparing versions: %v\n", err)
+		http.Error(w, "Failed to compare versions", http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) compareVersions(project string, from string, to string) (compareRes, error) {
+	fromFiles, err := s.fileSet(project, from)
+	if err != nil {
+		return compareRes{}, err
+	}
+	toFiles, err := s.fileSet(project, to)
+	if err != nil {
+		return compareRes{}, err
+	}
+
+	res := compareRes{
+		From:     from,
+		To:       to,
+		Added:    []string{},
+		Removed:  []string{},
+		Modified: []modifiedRes{},
+	}
+
+	for file := range toFiles {
+		if _, ok := fromFiles[file]; !ok {
+			res.Added = append(res.Added, file)
+		}
+	}
+
+	for file := range fromFiles {
+		if _, ok := toFiles[file]; !ok {
+			res.Removed = append(res.Removed, file)
+			continue
+		}
+
+		if fromFiles[file].Pin == toFiles[file].Pin {
+			continue
+		}
+
+		// Stored again, so modified even if the audio turns out the
+		// same, as then something else about the file changed.
+		regions, err := s.changedRegions(project, from, to, file)
+		if err != nil {
+			reason := uncomparableReason(err)
+			if reason == "" {
+				return res, fmt.Errorf("failed to compare %s: %w", file, err)
+			}
+			res.Modified = append(res.Modified, modifiedRes{File: file, Regions: []regionRes{}, Reason: reason})
+			continue
+		}
+		res.Modified = append(res.Modified, modifiedRes{File: file, Regions: regions})
+	}
+
+	sort.Strings(res.Added)
+	sort.Strings(res.Removed)
+	sort.Slice(res.Modified, func(i, j int) bool {
+		return res.Modified[i].File < res.Modified[j].File
+	})
+
+	return res, nil
+}
+
+// uncomparableReason says why changedRegions could not compare a file's
+// audio, or returns "" if err is a failure to read it.
+func uncomparableReason(err error) string {
+	switch {
+	case errors.Is(err, compare.ErrFormatMismatch):
+		return "format changed"
+	case isAudioFormatError(err):
+		return "not audio"
+	case errors.Is(err, errPatchedFile):
+		return "changed by a patch"
+	default:
+		return ""
+	}
+}
+
+// trackedFile is a file as of some version.
+type trackedFile struct {
+	index.FileRecord
+	// Pin whose archive holds the file
+	Pin string `json:"pin"`
+}
+
+// fileSet returns the files tracked as of version, replaying the changes
+// of every pin leading up to it.
+func (s *Server) fileSet(project string, version string) (map[string]trackedFile, error) {
+	var chain []string
+	seen := map[string]bool{}
+	for v := version; v != ""; {
+		if seen[v] {
+			return nil, fmt.Errorf("%w at %s in %s", errHistoryCycle, v, project)
+		}
+		seen[v] = true
+
+		pin, err := s.Index.Pin(project, v)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, v)
+		v = pin.Parent
+	}
+	reverse(chain)
+
+	files := map[string]trackedFile{}
+	for _, v := range chain {
+		pin, err := s.Index.Pin(project, v)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range pin.Files {
+			files[f.Path] = trackedFile{FileRecord: f, Pin: v}
+		}
+		for _, diff := range pin.Diffs {
+			if diff.Type == "removed" {
+				delete(files, diff.File)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// changedRegions runs the compare engine over a file as of two versions.
+func (s *Server) changedRegions(project string, from string, to string, file string) ([]regionRes, error) {
+	dir, err := os.MkdirTemp("", "stewdio-compare-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	oldFile, err := s.extractFileAt(project, from, file, filepath.Join(dir, "old.wav"))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = oldFile.Close() }()
+
+	newFile, err := s.extractFileAt(project, to, file, filepath.Join(dir, "new.wav"))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = newFile.Close() }()
+
+	info, err := oldFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	wav, err := wavfile.Parse(oldFile, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if wav.Format.BlockAlign == 0 || wav.Format.SampleRate == 0 {
+		return nil, fmt.Errorf("%w: no sample rate or block alignment in %s", wavfile.ErrUnsupportedFormat, file)
+	}
+
+	hunks, err := compare.DiffFiles(oldFile, newFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return hunkRegions(hunks, wav.Format), nil
+}
+
+func hunkRegions(hunks []pinCmd.Hunk, format wavfile.Format) []regionRes {
+	seconds := func(bytes int64) float64 {
+		frames := bytes / int64(format.BlockAlign)
+		return float64(frames) / float64(format.SampleRate)
+	}
+
+	regions := make([]regionRes, 0, len(hunks))
+	for _, hunk := range hunks {
+		start := seconds(hunk.Offset)
+		regions = append(regions, regionRes{
+			Type:  hunkTypes[hunk.Operation],
+			Start: start,
+			End:   start + seconds(hunk.Length),
+		})
+	}
+	return regions
+}
+
+// extractFileAt writes file as of version to name and opens it.
+func (s *Server) extractFileAt(project string, version string, file string, name string) (*os.File, error) {
+	r, _, _, err := s.openFileAt(project, version, file)
+	if err != nil {
+		return nil, err
+	}
+	err = writeFile(name, r)
+	_ = r.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(name)
+}
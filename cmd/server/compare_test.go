@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"stewdio/internal/index"
+	"stewdio/internal/store"
+	"stewdio/internal/testutil"
+	"stewdio/internal/wavfile"
+)
+
+// testWAV returns a mono WAV file at 1000 Hz holding data.
+func testWAV(bitDepth int, data []byte) string {
+	format := wavfile.Format{
+		AudioFormat:   wavfile.FormatPCM,
+		Channels:      1,
+		SampleRate:    1000,
+		ByteRate:      uint32(1000 * bitDepth / 8),
+		BlockAlign:    uint16(bitDepth / 8),
+		BitsPerSample: uint16(bitDepth),
+	}
+	return string(testutil.WAV(format, data))
+}
+
+func TestHandleCompare(t *testing.T) {
+	idx, err := index.Open(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = idx.Close() }()
+	s := &Server{Store: index.NewStore(store.NewMemoryStore(), idx), Index: idx}
+
+	audio := bytes.Repeat([]byte{1, 2, 3, 4}, 1000)
+	changed := append([]byte{}, audio...)
+	copy(changed[1000:], bytes.Repeat([]byte{9}, 200))
+
+	pins := []struct {
+		version string
+		parent  string
+		files   map[string]string
+	}{
+		{"0.1", "", map[string]string{
+			"files/kick.wav": testWAV(16, audio),
+			"files/bass.wav": testWAV(16, audio),
+			"files/hat.wav":  testWAV(16, audio),
+		}},
+		// kick.wav is stored again with the same audio.
+		{"0.2", "0.1", map[string]string{
+			"files/kick.wav":  testWAV(16, audio),
+			"files/bass.wav":  testWAV(16, changed),
+			"files/snare.wav": testWAV(16, audio),
+			"diffs.json":      `[{"file":"hat.wav","type":"removed"},{"file":"snare.wav","type":"added"}]`,
+		}},
+		{"0.3", "0.2", map[string]string{"files/bass.wav": "not audio"}},
+		// bass.wav stored again with 24-bit samples
+		{"0.4", "0.3", map[string]string{"files/bass.wav": testWAV(24, audio[:3000])}},
+	}
+	for _, pin := range pins {
+		pin.files["message"] = "pin " + pin.version
+		archive := testutil.Archive(t, pin.files)
+		if err := s.Store.PutPinFrom("song", pin.version, pin.parent, bytes.NewReader(archive)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A damaged index, where two pins are each other's parent
+	for _, pin := range []index.PinRecord{{Version: "0.8", Parent: "0.9"}, {Version: "0.9", Parent: "0.8"}} {
+		if err := idx.PutPin("song", pin); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := chi.NewRouter()
+	router.Get("/projects/{project}/compare", s.HandleCompare)
+	get := func(from string, to string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/projects/song/compare?from="+from+"&to="+to, nil))
+		return rec
+	}
+
+	rec := get("0.1", "0.2")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body.String())
+	}
+	var res compareRes
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(res.Added, []string{"snare.wav"}) || !slices.Equal(res.Removed, []string{"hat.wav"}) {
+		t.Errorf("got added %v and removed %v, expected [snare.wav] and [hat.wav]", res.Added, res.Removed)
+	}
+	if len(res.Modified) != 2 {
+		t.Fatalf("got modified %+v, expected bass.wav and kick.wav", res.Modified)
+	}
+	bass, kick := res.Modified[0], res.Modified[1]
+	if bass.File != "bass.wav" || len(bass.Regions) != 1 || bass.Regions[0].Type != "replaced" ||
+		bass.Regions[0].Start != 0.5 || bass.Regions[0].End != 0.6 {
+		t.Errorf("got %+v for bass.wav, expected one region replaced from 0.5s to 0.6s", bass)
+	}
+	if kick.File != "kick.wav" || kick.Regions == nil || len(kick.Regions) != 0 {
+		t.Errorf("got %+v for kick.wav, expected no regions", kick)
+	}
+
+	// Unchanged files are left out.
+	rec = get("0.2", "0.2")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"modified":[]`) {
+		t.Errorf("comparing a version with itself: got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Files whose audio cannot be compared are still listed, with why.
+	for _, c := range []struct {
+		from   string
+		to     string
+		reason string
+	}{
+		{"0.2", "0.3", "not audio"},
+		{"0.2", "0.4", "format changed"},
+	} {
+		rec := get(c.from, c.to)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s to %s: got %d: %s", c.from, c.to, rec.Code, rec.Body.String())
+			continue
+		}
+		var res compareRes
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		expected := []modifiedRes{{File: "bass.wav", Regions: []regionRes{}, Reason: c.reason}}
+		if len(res.Modified) != 1 || res.Modified[0].File != "bass.wav" || res.Modified[0].Regions == nil ||
+			len(res.Modified[0].Regions) != 0 || res.Modified[0].Reason != c.reason {
+			t.Errorf("%s to %s: got modified %+v, expected %+v", c.from, c.to, res.Modified, expected)
+		}
+	}
+
+	cases := []struct {
+		from    string
+		to      string
+		status  int
+		message string
+	}{
+		{"0.1", "0.8", http.StatusInternalServerError, "Failed to compare versions"},
+		{"0.1", "0.7", http.StatusNotFound, "not found"},
+		{"0.1", "01.2", http.StatusBadRequest, "invalid version"},
+	}
+	for _, c := range cases {
+		rec := get(c.from, c.to)
+		if rec.Code != c.status || !strings.Contains(rec.Body.String(), c.message) {
+			t.Errorf("%s to %s: got %d: %s, expected %d mentioning %q",
+				c.from, c.to, rec.Code, strings.TrimSpace(rec.Body.String()), c.status, c.message)
+		}
+	}
+}
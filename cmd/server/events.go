@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"stewdio/internal/auth"
+	"stewdio/internal/events"
+)
+
+// Comments sent on quiet streams, so proxies do not time them out
+const heartbeatInterval = 30 * time.Second
+
+// HandleProjectEvents streams a project's events as Server-Sent Events
+// until the client goes away, the project is deleted, or the user loses
+// their role in it.
+func (s *Server) HandleProjectEvents(w http.ResponseWriter, r *http.Request) {
+	project := chi.URLParam(r, "project")
+	user := auth.UserFromContext(r.Context())
+
+	s.streamEvents(w, r, func(e events.Event) bool {
+		return e.Project == project
+	}, func(e events.Event) bool {
+		switch e.Type {
+		case events.ProjectDeleted:
+			return true
+		case events.MembersChanged:
+			role, err := s.Users.Role(project, user)
+			return err != nil || !role.Allows(auth.RoleReader)
+		}
+		return false
+	})
+}
+
+// HandleUserEvents streams events for every project the user can read,
+// including ones created after the stream started.
+func (s *Server) HandleUserEvents(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	s.streamEvents(w, r, func(e events.Event) bool {
+		role, err := s.Users.Role(e.Project, user)
+		return err == nil && role.Allows(auth.RoleReader)
+	}, nil)
+}
+
+// publish sends out an event that user caused.
+func (s *Server) publish(eventType string, project string, user string) {
+	s.Events.Publish(events.Event{Type: eventType, Project: project, User: user})
+}
+
+// pinStored does everything that follows a pin being stored.
+func (s *Server) pinStored(project string, version string, parent string, user string) {
+	s.recordAuthor(project, version, user)
+	s.claimProject(project, user)
+
+	s.Events.Publish(events.Event{
+		Type:    events.PinCreated,
+		Project: project,
+		Version: version,
+		Parent:  parent,
+		User:    user,
+	})
+}
+
+// streamEvents sends the events filter accepts. If last is not nil, the
+// stream ends after the first event it returns true for.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, filter func(events.Event) bool, last func(events.Event) bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Sent by EventSource when it reconnects
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	ch, cancel := s.Events.Subscribe(lastID, filter)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(e)
+			if err != nil {
+				fmt.Printf("error encoding event: %v\n", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+				return
+			}
+			if last != nil && last(e) {
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"stewdio/internal/auth"
+)
+
+// openStream starts following url with token, and returns a channel that
+// receives everything the stream sent once it ends.
+func openStream(t *testing.T, url string, token string) <-chan string {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("opening %s: got %s", url, res.Status)
+	}
+
+	ended := make(chan string, 1)
+	go func() {
+		defer func() { _ = res.Body.Close() }()
+		data, _ := io.ReadAll(res.Body)
+		ended <- string(data)
+	}()
+	return ended
+}
+
+func send(t *testing.T, method string, url string, token string, body string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode >= 300 {
+		t.Fatalf("%s %s: got %s", method, url, res.Status)
+	}
+}
+
+func waitForEnd(t *testing.T, ended <-chan string, why string) string {
+	t.Helper()
+
+	select {
+	case data := <-ended:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stream was still open after %s", why)
+		return ""
+	}
+}
+
+func TestProjectEventsEnd(t *testing.T) {
+	s, oliveToken := newTestServer(t, t.TempDir())
+	server := httptest.NewServer(s.Router)
+	defer server.Close()
+	defer s.Events.Close()
+
+	if err := s.Users.SetRole("song", "rita", auth.RoleReader); err != nil {
+		t.Fatal(err)
+	}
+	ritaToken, err := s.Users.NewToken("rita")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := server.URL + "/api/v1/projects/song"
+
+	t.Run("member removed", func(t *testing.T) {
+		ended := openStream(t, api+"/events", ritaToken)
+		send(t, http.MethodDelete, api+"/members/rita", oliveToken, "")
+
+		data := waitForEnd(t, ended, "the member was removed")
+		if !strings.Contains(data, "event: project.members") {
+			t.Errorf("stream ended without saying why: %q", data)
+		}
+	})
+
+	t.Run("member kept", func(t *testing.T) {
+		send(t, http.MethodPut, api+"/members/rita", oliveToken, `{"role":"reader"}`)
+		ended := openStream(t, api+"/events", ritaToken)
+		send(t, http.MethodPut, api+"/members/rita", oliveToken, `{"role":"writer"}`)
+
+		select {
+		case data := <-ended:
+			t.Errorf("stream ended though the member can still read: %q", data)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("project deleted", func(t *testing.T) {
+		ended := openStream(t, api+"/events", oliveToken)
+		send(t, http.MethodDelete, api, oliveToken, "")
+
+		data := waitForEnd(t, ended, "the project was deleted")
+		if !strings.Contains(data, "event: project.deleted") {
+			t.Errorf("stream ended without saying why: %q", data)
+		}
+	})
+}
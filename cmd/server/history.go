@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"stewdio/internal/refs"
+	"stewdio/internal/store"
+)
+
+// Pins form a linear history: each one names the version it was made
+// from, and only a pin made from the current head is accepted. Anything
+// else would silently drop whatever was pinned in between.
+
+type pushConflictRes struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	// Current head of the project, which the client should pull first
+	Latest string `json:"latest"`
+	Parent string `json:"parent"`
+}
+
+// Only one pin per project is checked and stored at a time, so two
+// pushes from the same head cannot both succeed.
+type projectLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func (l *projectLocks) lock(project string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*sync.Mutex{}
+	}
+	m, ok := l.locks[project]
+	if !ok {
+		m = &sync.Mutex{}
+		l.locks[project] = m
+	}
+	l.mu.Unlock()
+
+	m.Lock()
+	return m.Unlock
+}
+
+// head returns the latest version pinned in a project, or "" if there
+// are none yet.
+func (s *Server) head(project string) (string, error) {
+	versions, err := s.Store.ListPins(project)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	sortVersionNumbers(versions)
+	if len(versions) == 0 {
+		return "", nil
+	}
+	return versions[len(versions)-1], nil
+}
+
+// checkParent writes a conflict response and returns false unless parent
+// is the project's head and version comes after it.
+func (s *Server) checkParent(w http.ResponseWriter, project string, version string, parent string) bool {
+	head, err := s.head(project)
+	if err != nil {
+		http.Error(w, "Error accessing project", http.StatusInternalServerError)
+		return false
+	}
+
+	if parent != head {
+		writePushConflict(w, head, parent)
+		return false
+	}
+
+	// Otherwise the pin would not become the head, and the next one made
+	// from the head would lose it.
+	if head != "" && !refs.VersionLess(head, version) {
+		writeJSON(w, http.StatusConflict, pushConflictRes{
+			Error:   "version_not_newer",
+			Message: "Version " + version + " is not newer than the latest version " + head,
+			Latest:  head,
+			Parent:  parent,
+		})
+		return false
+	}
+
+	return true
+}
+
+func writePushConflict(w http.ResponseWriter, head string, parent string) {
+	msg := "Pin is not based on the latest version " + head + ", pull it first"
+	if head == "" {
+		msg = "Project has no pins yet, the first pin cannot have a parent"
+	}
+
+	writeJSON(w, http.StatusConflict, pushConflictRes{
+		Error:   "not_fast_forward",
+		Message: msg,
+		Latest:  head,
+		Parent:  parent,
+	})
+}
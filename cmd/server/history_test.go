@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckParent(t *testing.T) {
+	s, _ := newTestServer(t, t.TempDir())
+	defer s.Events.Close()
+
+	cases := []struct {
+		project string
+		version string
+		parent  string
+		ok      bool
+		error   string
+	}{
+		{"song", "0.2", "0.1", true, ""},
+		{"song", "1.0", "0.1", true, ""},
+		{"song", "0.3", "", false, "not_fast_forward"},
+		{"song", "0.3", "0.0", false, "not_fast_forward"},
+		{"song", "0.1", "0.1", false, "version_not_newer"},
+		{"song", "0.0", "0.1", false, "version_not_newer"},
+		{"keep", "0.1", "", true, ""},
+		{"keep", "0.1", "0.1", false, "not_fast_forward"},
+	}
+
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		ok := s.checkParent(rec, c.project, c.version, c.parent)
+		if ok != c.ok {
+			t.Errorf("%s %s from %q: got %v, expected %v", c.project, c.version, c.parent, ok, c.ok)
+			continue
+		}
+		if ok {
+			continue
+		}
+
+		var res pushConflictRes
+		if rec.Code != http.StatusConflict || json.NewDecoder(rec.Body).Decode(&res) != nil {
+			t.Errorf("%s %s from %q: got %d, expected a conflict", c.project, c.version, c.parent, rec.Code)
+			continue
+		}
+		latest := map[string]string{"song": "0.1", "keep": ""}[c.project]
+		if res.Error != c.error || res.Latest != latest {
+			t.Errorf("%s %s from %q: got %+v, expected %s with latest %q", c.project, c.version, c.parent, res, c.error, latest)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+
+	"stewdio/internal/auth"
+	"stewdio/internal/events"
+	"stewdio/internal/store"
+)
+
+// requireRole lets a request through only if its user has at least min
+// in the project. Users with no role at all are told the project does
+// not exist, so its name is not leaked.
+func (s *Server) requireRole(min auth.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			project := chi.URLParam(r, "project")
+			user := auth.UserFromContext(r.Context())
+
+			role, err := s.Users.Role(project, user)
+			if err != nil {
+				fmt.Printf("error checking project role: %v\n", err)
+				http.Error(w, "Unable to check access", http.StatusInternalServerError)
+				return
+			}
+
+			if role == "" {
+				// Anyone may push the first pin of a project that does
+				// not exist yet, which makes them its owner.
+				if min == auth.RoleWriter && s.isUnclaimed(project) {
+					next.ServeHTTP(w, r)
+					return
+				}
+
+				http.Error(w, "Project not found", http.StatusNotFound)
+				return
+			}
+
+			if !role.Allows(min) {
+				http.Error(w, fmt.Sprintf("This needs the %s role", min), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (s *Server) isUnclaimed(project string) bool {
+	members, err := s.Users.Members(project)
+	if err != nil || len(members) > 0 {
+		return false
+	}
+
+	_, err = s.Store.GetProject(project)
+	return errors.Is(err, store.ErrNotFound)
+}
+
+// claimProject makes user the owner of a project nobody has a role in,
+// once their first pin has created it.
+func (s *Server) claimProject(project string, user string) {
+	if members, err := s.Users.Members(project); err == nil && len(members) == 0 {
+		if err := s.Users.SetRole(project, user, auth.RoleOwner); err != nil {
+			fmt.Printf("error setting project owner: %v\n", err)
+		}
+	}
+}
+
+type memberRes struct {
+	User string    `json:"user"`
+	Role auth.Role `json:"role"`
+}
+
+func (s *Server) HandleListMembers(w http.ResponseWriter, r *http.Request) {
+	project := chi.URLParam(r, "project")
+
+	members, err := s.Users.Members(project)
+	if err != nil {
+		fmt.Printf("error listing members: %v\n", err)
+		http.Error(w, "Unable to list members", http.StatusInternalServerError)
+		return
+	}
+
+	res := []memberRes{}
+	for user, role := range members {
+		res = append(res, memberRes{User: user, Role: role})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].User < res[j].User
+	})
+
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+type setMemberRequest struct {
+	Role string `json:"role"`
+}
+
+func (s *Server) HandleSetMember(w http.ResponseWriter, r *http.Request) {
+	project := chi.URLParam(r, "project")
+	user := chi.URLParam(r, "user")
+
+	var req setMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	role, err := auth.ParseRole(req.Role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.Users.SetRole(project, user, role); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrNoUser):
+			http.Error(w, "User not found", http.StatusNotFound)
+		case errors.Is(err, auth.ErrLastOwner):
+			http.Error(w, "A project needs at least one owner", http.StatusConflict)
+		default:
+			http.Error(w, "Failed to set role", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.publish(events.MembersChanged, project, auth.UserFromContext(r.Context()))
+
+	_ = json.NewEncoder(w).Encode(memberRes{User: user, Role: role})
+}
+
+func (s *Server) HandleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	project := chi.URLParam(r, "project")
+	user := chi.URLParam(r, "user")
+
+	if err := s.Users.RemoveMember(project, user); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrNoUser):
+			http.Error(w, "User is not a member", http.StatusNotFound)
+		case errors.Is(err, auth.ErrLastOwner):
+			http.Error(w, "A project needs at least one owner", http.StatusConflict)
+		default:
+			http.Error(w, "Failed to remove member", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Ends the removed user's streams of the project.
+	s.publish(events.MembersChanged, project, auth.UserFromContext(r.Context()))
+
+	_, _ = w.Write([]byte("Member removed"))
+}
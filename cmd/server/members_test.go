@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"stewdio/internal/auth"
+	"stewdio/internal/index"
+	"stewdio/internal/store"
+)
+
+// newTestUsers makes a registry holding names, and returns it with a
+// token for each of them.
+func newTestUsers(t *testing.T, names ...string) (*auth.Users, map[string]string) {
+	t.Helper()
+
+	users, err := auth.OpenUsers(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := map[string]string{}
+	for _, name := range names {
+		if err := users.Add(name); err != nil {
+			t.Fatal(err)
+		}
+		token, err := users.NewToken(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[name] = token
+	}
+	return users, tokens
+}
+
+func TestRequireRole(t *testing.T) {
+	users, tokens := newTestUsers(t, "olive", "walt", "rita", "nobody")
+	idx, err := index.Open(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = idx.Close() }()
+	st := index.NewStore(store.NewMemoryStore(), idx)
+
+	// "song" exists with a member of each role. "orphan" exists with
+	// nobody in it, and "fresh" does not exist at all.
+	for _, project := range []string{"song", "orphan"} {
+		if err := st.CreateProject(project); err != nil {
+			t.Fatal(err)
+		}
+	}
+	roles := map[string]auth.Role{"olive": auth.RoleOwner, "walt": auth.RoleWriter, "rita": auth.RoleReader}
+	for user, role := range roles {
+		if err := users.SetRole("song", user, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &Server{Store: st, Users: users}
+	router := chi.NewRouter()
+	router.Use(users.Middleware)
+	for _, min := range []auth.Role{auth.RoleOwner, auth.RoleWriter, auth.RoleReader} {
+		router.With(s.requireRole(min)).Get("/"+string(min)+"/{project}", func(w http.ResponseWriter, r *http.Request) {})
+	}
+
+	cases := []struct {
+		user    string
+		min     auth.Role
+		project string
+		status  int
+	}{
+		{"olive", auth.RoleOwner, "song", http.StatusOK},
+		{"olive", auth.RoleWriter, "song", http.StatusOK},
+		{"olive", auth.RoleReader, "song", http.StatusOK},
+		{"walt", auth.RoleOwner, "song", http.StatusForbidden},
+		{"walt", auth.RoleWriter, "song", http.StatusOK},
+		{"walt", auth.RoleReader, "song", http.StatusOK},
+		{"rita", auth.RoleOwner, "song", http.StatusForbidden},
+		{"rita", auth.RoleWriter, "song", http.StatusForbidden},
+		{"rita", auth.RoleReader, "song", http.StatusOK},
+
+		// Without a role, a project looks like it does not exist.
+		{"nobody", auth.RoleOwner, "song", http.StatusNotFound},
+		{"nobody", auth.RoleWriter, "song", http.StatusNotFound},
+		{"nobody", auth.RoleReader, "song", http.StatusNotFound},
+
+		// Anyone may push to a project that does not exist yet, but
+		// not to one that exists with nobody in it.
+		{"nobody", auth.RoleWriter, "fresh", http.StatusOK},
+		{"nobody", auth.RoleReader, "fresh", http.StatusNotFound},
+		{"nobody", auth.RoleOwner, "fresh", http.StatusNotFound},
+		{"nobody", auth.RoleWriter, "orphan", http.StatusNotFound},
+		{"olive", auth.RoleWriter, "orphan", http.StatusNotFound},
+
+		// Roles in one project carry no weight in another.
+		{"olive", auth.RoleReader, "fresh", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/"+string(c.min)+"/"+c.project, nil)
+		req.Header.Set("Authorization", "Bearer "+tokens[c.user])
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != c.status {
+			t.Errorf("%s needing %s in %s: got %d, expected %d: %s",
+				c.user, c.min, c.project, rec.Code, c.status, bytes.TrimSpace(rec.Body.Bytes()))
+		}
+	}
+}
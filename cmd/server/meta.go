@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"stewdio/internal/refs"
+	"stewdio/internal/store"
+)
+
+type pinMetaRes struct {
+	Version string    `json:"version"`
+	Parent  string    `json:"parent"`
+	Message string    `json:"message"`
+	Author  string    `json:"author"`
+	Created time.Time `json:"created"`
+	// Size and hex SHA-256 of the archive
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Every file tracked as of this pin, sorted by path. Most are
+	// stored in the archive of an earlier pin, which Pin names.
+	Files []trackedFile `json:"files"`
+	Diffs []refs.Diff   `json:"diffs"`
+}
+
+// HandleGetPinMeta describes a pin without sending its archive. It is
+// served from the index, which read the archive when it was pushed.
+func (s *Server) HandleGetPinMeta(w http.ResponseWriter, r *http.Request) {
+	project := chi.URLParam(r, "project")
+	version := chi.URLParam(r, "version")
+
+	pin, err := s.Index.Pin(project, version)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Version not found", http.StatusNotFound)
+		} else {
+			fmt.Printf("error reading pin metadata: %v\n", err)
+			http.Error(w, "Error accessing project", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	files, err := s.fileSet(project, version)
+	if err != nil {
+		fmt.Printf("error listing pin files: %v\n", err)
+		http.Error(w, "Error accessing project", http.StatusInternalServerError)
+		return
+	}
+
+	res := pinMetaRes{
+		Version: pin.Version,
+		Parent:  pin.Parent,
+		Message: pin.Message,
+		Author:  pin.Author,
+		Created: pin.Created,
+		Size:    pin.Size,
+		SHA256:  pin.SHA256,
+		Files:   make([]trackedFile, 0, len(files)),
+		Diffs:   pin.Diffs,
+	}
+	for _, f := range files {
+		res.Files = append(res.Files, f)
+	}
+	sort.Slice(res.Files, func(i, j int) bool {
+		return res.Files[i].Path < res.Files[j].Path
+	})
+	if res.Diffs == nil {
+		res.Diffs = []refs.Diff{}
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// recordAuthor notes who pushed a pin, which is not in its archive.
+func (s *Server) recordAuthor(project string, version string, user string) {
+	if err := s.Index.SetAuthor(project, version, user); err != nil {
+		fmt.Printf("error recording pin author: %v\n", err)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"stewdio/internal/index"
+	"stewdio/internal/refs"
+)
+
+func TestHandleGetPinMeta(t *testing.T) {
+	idx, err := index.Open(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = idx.Close() }()
+
+	pins := []index.PinRecord{
+		{Version: "0.1", Files: []index.FileRecord{{Path: "kick.wav", Size: 4}, {Path: "bass.wav", Size: 8}}},
+		{Version: "0.2", Parent: "0.1", Files: []index.FileRecord{{Path: "snare.wav", Size: 5}}},
+		{Version: "0.3", Parent: "0.2", Message: "no kick", Diffs: []refs.Diff{{File: "kick.wav", Type: "removed"}}},
+	}
+	for _, pin := range pins {
+		if err := idx.PutPin("song", pin); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &Server{Index: idx}
+	router := chi.NewRouter()
+	router.Get("/projects/{project}/pins/{version}/meta", s.HandleGetPinMeta)
+
+	cases := []struct {
+		version string
+		files   []trackedFile
+	}{
+		{"0.1", []trackedFile{
+			{index.FileRecord{Path: "bass.wav", Size: 8}, "0.1"},
+			{index.FileRecord{Path: "kick.wav", Size: 4}, "0.1"},
+		}},
+		{"0.3", []trackedFile{
+			{index.FileRecord{Path: "bass.wav", Size: 8}, "0.1"},
+			{index.FileRecord{Path: "snare.wav", Size: 5}, "0.2"},
+		}},
+	}
+
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/projects/song/pins/"+c.version+"/meta", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", c.version, rec.Code, rec.Body.String())
+		}
+
+		var res pinMetaRes
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(res.Files, c.files) {
+			t.Errorf("%s: got files %+v, expected %+v", c.version, res.Files, c.files)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/projects/song/pins/0.9/meta", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing pin: got %d, expected %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stewdio/internal/peaks"
+	"stewdio/internal/wavfile"
+)
+
+// HandleFetchPeaks serves min/max peaks for drawing a file's waveform as
+// of a version. zoom picks the detail: level 0 has one point for every
+// peaks.BaseSamplesPerPixel frames, and each level up halves that. The
+// binary format is the default; ask for JSON with format=json or an
+// Accept header.
+func (s *Server) HandleFetchPeaks(w http.ResponseWriter, r *http.Request) {
+	project := chi.URLParam(r, "project")
+	version := chi.URLParam(r, "version")
+
+	filename := r.URL.Query().Get("file")
+	if filename == "" {
+		http.Error(w, "Missing file parameter", http.StatusBadRequest)
+		return
+	}
+
+	zoom := 0
+	if z := r.URL.Query().Get("zoom"); z != "" {
+		var err error
+		zoom, err = strconv.Atoi(z)
+		if err != nil || zoom < 0 || zoom > peaks.MaxZoom {
+			http.Error(w, fmt.Sprintf("Invalid zoom, expected 0 to %d", peaks.MaxZoom), http.StatusBadRequest)
+			return
+		}
+	}
+
+	asJSON := r.URL.Query().Get("format") == "json" ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+
+	f, etag, modified, err := s.openFileAt(project, version, filename)
+	if err != nil {
+		writeOpenFileError(w, err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	// The file's ETag changes whenever its audio does.
+	p, err := s.Peaks.Get(project+"\x00"+etag, func() (*peaks.Peaks, error) {
+		size, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		return peaks.Compute(readerAt(f), size)
+	})
+	if err != nil {
+		if isAudioFormatError(err) {
+			http.Error(w, "Cannot draw file: "+err.Error(), http.StatusUnprocessableEntity)
+		} else {
+			fmt.Printf("error computing peaks: %v\n", err)
+			http.Error(w, "Failed to compute peaks", http.StatusInternalServerError)
+		}
+		return
+	}
+	p = p.Zoom(zoom)
+
+	var buf bytes.Buffer
+	contentType := "application/octet-stream"
+	if asJSON {
+		contentType = "application/json"
+		err = json.NewEncoder(&buf).Encode(p)
+	} else {
+		err = p.WriteBinary(&buf)
+	}
+	if err != nil {
+		fmt.Printf("error encoding peaks: %v\n", err)
+		http.Error(w, "Failed to encode peaks", http.StatusInternalServerError)
+		return
+	}
+
+	format := "dat"
+	if asJSON {
+		format = "json"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", fmt.Sprintf(`%s-peaks-%d-%s"`, strings.TrimSuffix(etag, `"`), zoom, format))
+	w.Header().Set("Cache-Control", immutableCacheControl)
+	w.Header().Add("Vary", "Accept")
+
+	http.ServeContent(w, r, "", modified, bytes.NewReader(buf.Bytes()))
+}
+
+// isAudioFormatError reports whether err means a file is not audio the
+// server can decode, rather than that something went wrong reading it.
+func isAudioFormatError(err error) bool {
+	return errors.Is(err, wavfile.ErrNotWAV) || errors.Is(err, wavfile.ErrNoFormat) ||
+		errors.Is(err, wavfile.ErrNoData) || errors.Is(err, wavfile.ErrUnsupportedFormat)
+}
+
+// readerAt lets wavfile read from files that can only seek, such as
+// those decompressed from an archive.
+func readerAt(rs io.ReadSeeker) io.ReaderAt {
+	if ra, ok := rs.(io.ReaderAt); ok {
+		return ra
+	}
+	return &seekReaderAt{rs: rs}
+}
+
+type seekReaderAt struct {
+	rs io.ReadSeeker
+}
+
+func (r *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(r.rs, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"stewdio/internal/preview"
+)
+
+// HandleFetchPreview serves a 16-bit WAV rendition of a file as of a
+// version, at the sample rate given by rate and with channels set to
+// mono or stereo. Previews are rendered once and then streamed from the
+// cache, honoring Range requests.
+func (s *Server) HandleFetchPreview(w http.ResponseWriter, r *http.Request) {
+	project := chi.URLParam(r, "project")
+	version := chi.URLParam(r, "version")
+
+	filename := r.URL.Query().Get("file")
+	if filename == "" {
+		http.Error(w, "Missing file parameter", http.StatusBadRequest)
+		return
+	}
+
+	opts := preview.Options{SampleRate: preview.DefaultSampleRate}
+	if rate := r.URL.Query().Get("rate"); rate != "" {
+		var err error
+		if opts.SampleRate, err = strconv.Atoi(rate); err != nil {
+			http.Error(w, "Invalid rate", http.StatusBadRequest)
+			return
+		}
+	}
+	switch r.URL.Query().Get("channels") {
+	case "":
+	case "1", "mono":
+		opts.Channels = 1
+	case "2", "stereo":
+		opts.Channels = 2
+	default:
+		http.Error(w, "Invalid channels, expected mono or stereo", http.StatusBadRequest)
+		return
+	}
+	if err := opts.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f, etag, modified, err := s.openFileAt(project, version, filename)
+	if err != nil {
+		writeOpenFileError(w, err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	key := fmt.Sprintf("%s\x00%s\x00%d\x00%d", project, etag, opts.SampleRate, opts.Channels)
+	rendition, err := s.Previews.Open(key, func(w io.Writer) error {
+		size, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		return preview.Render(w, readerAt(f), size, opts)
+	})
+	if err != nil {
+		if isAudioFormatError(err) {
+			http.Error(w, "Cannot preview file: "+err.Error(), http.StatusUnprocessableEntity)
+		} else {
+			fmt.Printf("error rendering preview: %v\n", err)
+			http.Error(w, "Failed to render preview", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer func() { _ = rendition.Close() }()
+
+	name := strings.TrimSuffix(path.Base(filename), path.Ext(filename)) + ".preview.wav"
+
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Content-Disposition", "inline; filename=\""+name+"\"")
+	w.Header().Set("ETag", fmt.Sprintf(`%s-preview-%d-%d"`, strings.TrimSuffix(etag, `"`), opts.SampleRate, opts.Channels))
+	w.Header().Set("Cache-Control", immutableCacheControl)
+
+	http.ServeContent(w, r, "", modified, rendition)
+}
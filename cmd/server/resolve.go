@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"stewdio/internal/index"
+	"stewdio/internal/store"
+)
+
+// A pin archive only holds the files added in that pin, under files/.
+// A file is served from the newest pin in its history that holds it.
+
+// errHistoryCycle means following parents led back to a pin already
+// seen, which only a damaged index can cause.
+var errHistoryCycle = errors.New("pin history has a cycle")
+
+// errPatchedFile means a pin marks a file modified without storing it
+// again. Such a change would have to be rebuilt from a patch, which the
+// server cannot apply yet.
+var errPatchedFile = errors.New("file was changed by a patch")
+
+// openFileAt opens file as of version, along with its ETag and the time
+// it last changed. A file nobody touched since an earlier pin is served
+// from that pin, so it shares its ETag.
+func (s *Server) openFileAt(project string, version string, file string) (io.ReadSeekCloser, string, time.Time, error) {
+	base, err := s.resolveFile(project, version, file)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+
+	f, info, err := store.OpenPinFileSeeker(s.Store, project, base, file)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	return f, fileETag(base, file, info), info.Pin.Created, nil
+}
+
+// writeOpenFileError answers a request for a file openFileAt could not
+// open.
+func writeOpenFileError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "File not found", http.StatusNotFound)
+	case errors.Is(err, errPatchedFile):
+		http.Error(w, "File was changed by a patch, which the server cannot apply yet", http.StatusNotImplemented)
+	default:
+		fmt.Printf("error reading pin file: %v\n", err)
+		http.Error(w, "Failed to read archive", http.StatusInternalServerError)
+	}
+}
+
+// resolveFile follows history back from version to the newest pin that
+// holds file, and returns its version. A file changed on the way by a
+// patch gives errPatchedFile.
+func (s *Server) resolveFile(project string, version string, file string) (string, error) {
+	seen := map[string]bool{}
+
+	for version != "" {
+		if seen[version] {
+			return "", fmt.Errorf("%w at %s in %s", errHistoryCycle, version, project)
+		}
+		seen[version] = true
+
+		pin, err := s.Index.Pin(project, version)
+		if err != nil {
+			return "", err
+		}
+
+		for _, f := range pin.Files {
+			if f.Path == file {
+				return version, nil
+			}
+		}
+		switch fileChange(pin, file) {
+		case "removed":
+			return "", store.ErrNotFound
+		case "modified":
+			return "", fmt.Errorf("%w: %s at %s in %s", errPatchedFile, file, version, project)
+		}
+
+		version = pin.Parent
+	}
+
+	return "", store.ErrNotFound
+}
+
+func fileChange(pin index.PinRecord, file string) string {
+	for _, diff := range pin.Diffs {
+		if diff.File == file {
+			return diff.Type
+		}
+	}
+	return ""
+}
+
+func reverse(versions []string) {
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+}
+
+func writeFile(name string, r io.Reader) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
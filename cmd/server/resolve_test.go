@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"stewdio/internal/index"
+	"stewdio/internal/refs"
+	"stewdio/internal/store"
+)
+
+func TestResolveFile(t *testing.T) {
+	idx, err := index.Open(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = idx.Close() }()
+
+	kick := []index.FileRecord{{Path: "kick.wav", Size: 4}}
+	pins := []index.PinRecord{
+		{Version: "0.1", Files: kick},
+		{Version: "0.2", Parent: "0.1"},
+		{Version: "0.3", Parent: "0.2", Diffs: []refs.Diff{{File: "kick.wav", Type: "removed"}}},
+		{Version: "0.4", Parent: "0.3", Files: kick},
+		// 0.5 and 0.6 are each other's parent.
+		{Version: "0.5", Parent: "0.6"},
+		{Version: "0.6", Parent: "0.5"},
+		{Version: "0.7", Parent: "0.7"},
+		// kick.wav changed by a patch rather than stored again
+		{Version: "0.8", Parent: "0.4", Diffs: []refs.Diff{{File: "kick.wav", Type: "modified"}}},
+		{Version: "0.9", Parent: "0.8"},
+	}
+	for _, pin := range pins {
+		if err := idx.PutPin("song", pin); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &Server{Index: idx}
+
+	cases := []struct {
+		version string
+		file    string
+		base    string
+		err     error
+	}{
+		{"0.1", "kick.wav", "0.1", nil},
+		{"0.2", "kick.wav", "0.1", nil},
+		{"0.3", "kick.wav", "", store.ErrNotFound},
+		{"0.4", "kick.wav", "0.4", nil},
+		{"0.2", "snare.wav", "", store.ErrNotFound},
+		{"1.0", "kick.wav", "", store.ErrNotFound},
+		{"0.5", "kick.wav", "", errHistoryCycle},
+		{"0.7", "kick.wav", "", errHistoryCycle},
+		{"0.8", "kick.wav", "", errPatchedFile},
+		{"0.9", "kick.wav", "", errPatchedFile},
+	}
+
+	for _, c := range cases {
+		base, err := s.resolveFile("song", c.version, c.file)
+		if base != c.base || !errors.Is(err, c.err) {
+			t.Errorf("%s as of %s: got %q, %v, expected %q, %v", c.file, c.version, base, err, c.base, c.err)
+		}
+	}
+
+	// The file endpoints say they cannot rebuild the file rather than
+	// serving it as it was before the patch.
+	router := chi.NewRouter()
+	router.Get("/projects/{project}/pins/{version}/file", s.HandleFetchFile)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/projects/song/pins/0.9/file?file=kick.wav", nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("fetching a patched file: got %d: %s, expected %d", rec.Code, rec.Body.String(), http.StatusNotImplemented)
+	}
+}
@@ -1,26 +1,34 @@
 package server
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"os"
 	"os/signal"
 	"path"
 	"path/filepath"
 	"sort"
-	"strings"
+	"strconv"
 	"syscall"
 	"time"
 
+	"stewdio/internal/auth"
 	cmdUtils "stewdio/internal/cmd/utils"
+	"stewdio/internal/events"
+	"stewdio/internal/index"
+	"stewdio/internal/peaks"
+	"stewdio/internal/preview"
 	"stewdio/internal/refs"
-	"stewdio/internal/utils"
+	"stewdio/internal/store"
+	"stewdio/internal/upload"
+	"stewdio/internal/validate"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/spf13/cobra"
@@ -29,28 +37,69 @@ import (
 type ServerOpts struct {
 	Port         int
 	DataLocation string
+	Storage      string
+	S3           store.S3Options
 }
 
 type Server struct {
-	DataDir string
-	Router  *chi.Mux
+	Store    *index.Store
+	Index    *index.Index
+	Users    *auth.Users
+	Uploads  *upload.Sessions
+	Peaks    *peaks.Cache
+	Previews *preview.Cache
+	Events   *events.Broker
+	Router   *chi.Mux
+
+	pushLocks projectLocks
 }
 
-func NewServer(dataDir string) *Server {
+// NewServer serves st, which keeps idx up to date as it is written to.
+func NewServer(st *index.Store, idx *index.Index, users *auth.Users, uploads *upload.Sessions, peakCache *peaks.Cache, previews *preview.Cache) *Server {
 	s := &Server{
-		DataDir: dataDir,
-		Router:  chi.NewRouter(),
+		Store:    st,
+		Index:    idx,
+		Users:    users,
+		Uploads:  uploads,
+		Peaks:    peakCache,
+		Previews: previews,
+		Events:   events.NewBroker(),
+		Router:   chi.NewRouter(),
 	}
 
 	s.Router.Route("/api/v1", func(r chi.Router) {
+		r.Use(users.Middleware)
+
+		r.Get("/user", s.HandleGetUser)
+		r.Get("/events", s.HandleUserEvents)
 		r.Get("/projects", s.ListProjectsHandler)
 		r.Post("/projects", s.CreateProjectHandler)
-		r.Delete("/projects/{project}", s.DeleteProjectHandler)
-		r.Get("/projects/{project}", s.GetProjectHandler)
-		r.Get("/projects/{project}/pins", s.HandleGetVersionList)
-		r.Post("/projects/{project}/pins", s.HandleUploadPin)
-		r.Get("/projects/{project}/pins/{version}", s.HandleFetchVersion)
-		r.Get("/projects/{project}/pins/{version}/file", s.HandleFetchFile)
+
+		owner := r.With(validateParams, s.requireRole(auth.RoleOwner))
+		writer := r.With(validateParams, s.requireRole(auth.RoleWriter))
+		reader := r.With(validateParams, s.requireRole(auth.RoleReader))
+
+		owner.Delete("/projects/{project}", s.DeleteProjectHandler)
+		reader.Get("/projects/{project}", s.GetProjectHandler)
+		reader.Get("/projects/{project}/pins", s.HandleGetVersionList)
+		reader.Get("/projects/{project}/compare", s.HandleCompare)
+		reader.Get("/projects/{project}/events", s.HandleProjectEvents)
+		writer.Post("/projects/{project}/pins", s.HandleUploadPin)
+		reader.Get("/projects/{project}/pins/{version}", s.HandleFetchVersion)
+		reader.Get("/projects/{project}/pins/{version}/file", s.HandleFetchFile)
+		reader.Get("/projects/{project}/pins/{version}/meta", s.HandleGetPinMeta)
+		reader.Get("/projects/{project}/pins/{version}/peaks", s.HandleFetchPeaks)
+		reader.Get("/projects/{project}/pins/{version}/preview", s.HandleFetchPreview)
+
+		writer.Post("/projects/{project}/uploads", s.HandleCreateUpload)
+		writer.Get("/projects/{project}/uploads/{upload}", s.HandleGetUpload)
+		writer.Put("/projects/{project}/uploads/{upload}/chunks/{chunk}", s.HandleUploadChunk)
+		writer.Post("/projects/{project}/uploads/{upload}/finalize", s.HandleFinalizeUpload)
+		writer.Delete("/projects/{project}/uploads/{upload}", s.HandleCancelUpload)
+
+		reader.Get("/projects/{project}/members", s.HandleListMembers)
+		owner.Put("/projects/{project}/members/{user}", s.HandleSetMember)
+		owner.Delete("/projects/{project}/members/{user}", s.HandleRemoveMember)
 	})
 
 	return s
@@ -68,25 +117,86 @@ func ServerCommand() *cobra.Command {
 	}
 
 	cmd.Flags().IntVarP(&opts.Port, "port", "p", 6969, "Port to listen on")
-	cmd.Flags().StringVarP(&opts.DataLocation, "data", "d", "./stewdio-data", "Directory in which to store data")
+	cmd.PersistentFlags().StringVarP(&opts.DataLocation, "data", "d", "./stewdio-data", "Directory in which to store data")
+	cmd.Flags().StringVar(&opts.Storage, "storage", "fs", "Storage backend to use: fs or s3")
+	cmd.Flags().StringVar(&opts.S3.Endpoint, "s3-endpoint", "", "S3 endpoint, e.g. s3.amazonaws.com or localhost:9000")
+	cmd.Flags().StringVar(&opts.S3.Bucket, "s3-bucket", "", "S3 bucket to store projects in")
+	cmd.Flags().StringVar(&opts.S3.Region, "s3-region", "", "S3 region")
+	cmd.Flags().StringVar(&opts.S3.Prefix, "s3-prefix", "", "Prefix for every object key in the bucket")
+	cmd.Flags().StringVar(&opts.S3.AccessKey, "s3-access-key", "", "S3 access key (default: $AWS_ACCESS_KEY_ID)")
+	cmd.Flags().StringVar(&opts.S3.SecretKey, "s3-secret-key", "", "S3 secret key (default: $AWS_SECRET_ACCESS_KEY)")
+	cmd.Flags().BoolVar(&opts.S3.UseSSL, "s3-ssl", true, "Connect to the S3 endpoint over HTTPS")
+
+	cmd.AddCommand(userCmd(&opts))
 
 	return cmd
 }
 
 func serverMain(opts *ServerOpts) error {
-	dataDir := opts.DataLocation
+	backend, err := openStore(opts)
+	if err != nil {
+		fmt.Println("error: failed to open storage:", err)
+		return err
+	}
+
+	users, err := openUsers(opts)
+	if err != nil {
+		fmt.Println("error: failed to load users:", err)
+		return err
+	}
+
+	if count, err := users.Count(); err == nil && count == 0 {
+		fmt.Println("warning: no users exist yet, so every request will be rejected")
+		fmt.Println("create one with \"stewdio server user add {NAME}\"")
+	}
+
+	// The index lives on local disk whatever the backend, and is rebuilt
+	// from the backend if it is new or was left incomplete.
+	idx, err := index.Open(filepath.Join(opts.DataLocation, "index.db"))
+	if err != nil {
+		fmt.Println("error: failed to open index:", err)
+		return err
+	}
+	defer func() { _ = idx.Close() }()
+
+	if !idx.Built() {
+		fmt.Println("building index from storage...")
+		if err := idx.Rebuild(backend); err != nil {
+			fmt.Println("error: failed to build index:", err)
+			return err
+		}
+	}
+	st := index.NewStore(backend, idx)
+
+	// Uploads in progress are kept on local disk whatever the backend.
+	uploads, err := upload.NewSessions(filepath.Join(opts.DataLocation, "uploads"))
+	if err != nil {
+		fmt.Println("error: failed to open upload sessions:", err)
+		return err
+	}
+
+	// So are computed waveform peaks and previews, which can always be
+	// made again.
+	peakCache, err := peaks.NewCache(filepath.Join(opts.DataLocation, "cache", "peaks"))
+	if err != nil {
+		fmt.Println("error: failed to open peaks cache:", err)
+		return err
+	}
+	previews, err := preview.NewCache(filepath.Join(opts.DataLocation, "cache", "previews"))
+	if err != nil {
+		fmt.Println("error: failed to open preview cache:", err)
+		return err
+	}
 
-	s := NewServer(dataDir)
+	s := NewServer(st, idx, users, uploads, peakCache, previews)
 
 	addr := fmt.Sprintf(":%d", opts.Port)
 	httpServer := &http.Server{
 		Addr:    addr,
 		Handler: s.Router,
 	}
-
-	if err := os.MkdirAll(dataDir, 0o755); err != nil {
-		return err
-	}
+	// Event streams never finish on their own.
+	httpServer.RegisterOnShutdown(s.Events.Close)
 
 	fmt.Println("hello, cruel world!")
 
@@ -115,21 +225,58 @@ func serverMain(opts *ServerOpts) error {
 	return nil
 }
 
+func openStore(opts *ServerOpts) (store.Store, error) {
+	switch opts.Storage {
+	case "fs":
+		return store.NewFSStore(opts.DataLocation)
+	case "s3":
+		s3 := opts.S3
+		if s3.AccessKey == "" {
+			s3.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		if s3.SecretKey == "" {
+			s3.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		return store.NewS3Store(s3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q, expected fs or s3", opts.Storage)
+	}
+}
+
 // Handler methods
+type userRes struct {
+	Name string `json:"name"`
+}
+
+func (s *Server) HandleGetUser(w http.ResponseWriter, r *http.Request) {
+	_ = json.NewEncoder(w).Encode(userRes{Name: auth.UserFromContext(r.Context())})
+}
+
 func (s *Server) ListProjectsHandler(w http.ResponseWriter, r *http.Request) {
-	dirs, err := os.ReadDir(s.DataDir)
+	projects, err := s.Store.ListProjects()
 	if err != nil {
 		fmt.Printf("error listing projects: %v\n", err)
 		http.Error(w, "Unable to list projects", http.StatusInternalServerError)
 		return
 	}
 
-	var projects []string
-	for _, entry := range dirs {
-		if entry.IsDir() {
-			projects = append(projects, entry.Name())
+	user := auth.UserFromContext(r.Context())
+
+	visible := []string{}
+	for _, project := range projects {
+		role, err := s.Users.Role(project, user)
+		if err != nil {
+			fmt.Printf("error checking project role: %v\n", err)
+			http.Error(w, "Unable to list projects", http.StatusInternalServerError)
+			return
+		}
+		if role != "" {
+			visible = append(visible, project)
 		}
 	}
+	projects = visible
+
+	sort.Strings(projects)
 
 	_ = json.NewEncoder(w).Encode(projects)
 }
@@ -148,18 +295,29 @@ func (s *Server) CreateProjectHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing project name", http.StatusBadRequest)
 		return
 	}
+	if err := validate.Name(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	path := filepath.Join(s.DataDir, req.Name)
-	if utils.PathExists(path) {
-		http.Error(w, "Project already exists", http.StatusConflict)
+	if err := s.Store.CreateProject(req.Name); err != nil {
+		if errors.Is(err, store.ErrExists) {
+			http.Error(w, "Project already exists", http.StatusConflict)
+		} else {
+			http.Error(w, "Failed to create project", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	if err := os.MkdirAll(path, 0o755); err != nil {
+	user := auth.UserFromContext(r.Context())
+	if err := s.Users.SetRole(req.Name, user, auth.RoleOwner); err != nil {
+		fmt.Printf("error setting project owner: %v\n", err)
 		http.Error(w, "Failed to create project", http.StatusInternalServerError)
 		return
 	}
 
+	s.publish(events.ProjectCreated, req.Name, user)
+
 	w.WriteHeader(http.StatusCreated)
 	_, _ = w.Write([]byte("Project created"))
 }
@@ -167,12 +325,22 @@ func (s *Server) CreateProjectHandler(w http.ResponseWriter, r *http.Request) {
 func (s *Server) DeleteProjectHandler(w http.ResponseWriter, r *http.Request) {
 	project := chi.URLParam(r, "project")
 
-	path := filepath.Join(s.DataDir, project)
-	if err := os.RemoveAll(path); err != nil {
-		http.Error(w, "Failed to delete project", http.StatusInternalServerError)
+	if err := s.Store.DeleteProject(project); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Project not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to delete project", http.StatusInternalServerError)
+		}
 		return
 	}
 
+	// Published while members still have their roles, so they hear of it.
+	s.publish(events.ProjectDeleted, project, auth.UserFromContext(r.Context()))
+
+	if err := s.Users.DeleteProject(project); err != nil {
+		fmt.Printf("error removing project members: %v\n", err)
+	}
+
 	_, _ = w.Write([]byte("Project deleted"))
 }
 
@@ -183,11 +351,10 @@ type projectInfoRes struct {
 
 func (s *Server) GetProjectHandler(w http.ResponseWriter, r *http.Request) {
 	project := chi.URLParam(r, "project")
-	path := filepath.Join(s.DataDir, project)
 
-	info, err := os.Stat(path)
+	info, err := s.Store.GetProject(project)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, store.ErrNotFound) {
 			http.Error(w, "Project not found", http.StatusNotFound)
 		} else {
 			http.Error(w, "Error accessing project", http.StatusInternalServerError)
@@ -196,8 +363,8 @@ func (s *Server) GetProjectHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	res := projectInfoRes{
-		Project:      project,
-		LastModified: info.ModTime(),
+		Project:      info.Name,
+		LastModified: info.LastModified,
 	}
 
 	_ = json.NewEncoder(w).Encode(res)
@@ -205,20 +372,31 @@ func (s *Server) GetProjectHandler(w http.ResponseWriter, r *http.Request) {
 
 type PinMetadata struct {
 	Version string `json:"version"`
+	// Version the pin was made from, empty for a project's first pin
+	Parent string `json:"parent"`
+	// Hex SHA-256 of the archive, checked before the pin is accepted
+	SHA256 string `json:"sha256"`
 }
 
+// HandleUploadPin reads a multipart body with a "meta" part followed by
+// a "file" part, streaming the file straight into storage.
 func (s *Server) HandleUploadPin(w http.ResponseWriter, r *http.Request) {
 	project := chi.URLParam(r, "project")
 
-	err := r.ParseMultipartForm(32 << 20)
+	mr, err := r.MultipartReader()
 	if err != nil {
-		http.Error(w, "Failed to parse multipart form", http.StatusBadRequest)
+		http.Error(w, "Expected a multipart body", http.StatusBadRequest)
+		return
+	}
+
+	metaPart, err := mr.NextPart()
+	if err != nil || metaPart.FormName() != "meta" {
+		http.Error(w, "Missing metadata, it must come before the file", http.StatusBadRequest)
 		return
 	}
 
-	metaField := r.FormValue("meta")
 	var meta PinMetadata
-	if err := json.Unmarshal([]byte(metaField), &meta); err != nil {
+	if err := json.NewDecoder(io.LimitReader(metaPart, 64<<10)).Decode(&meta); err != nil {
 		http.Error(w, "Invalid metadata JSON", http.StatusBadRequest)
 		return
 	}
@@ -227,41 +405,61 @@ func (s *Server) HandleUploadPin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing version", http.StatusBadRequest)
 		return
 	}
-
-	file, _, err := r.FormFile("file")
-	if err != nil {
-		http.Error(w, "Missing file", http.StatusBadRequest)
+	if err := validate.Version(meta.Version); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer func() { _ = file.Close() }()
+	if meta.SHA256 == "" {
+		http.Error(w, "Missing sha256", http.StatusBadRequest)
+		return
+	}
+	if meta.Parent != "" {
+		if err := validate.Version(meta.Parent); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-	projectDir := filepath.Join(s.DataDir, "projects", project, "objects", meta.Version)
-	if utils.PathExists(projectDir) {
-		http.Error(w, "Pin already exists", http.StatusConflict)
+	filePart, err := mr.NextPart()
+	if err != nil || filePart.FormName() != "file" {
+		http.Error(w, "Missing file", http.StatusBadRequest)
 		return
 	}
+	defer func() { _ = filePart.Close() }()
 
-	err = os.MkdirAll(projectDir, 0o755)
+	archive, err := store.VerifyReader(filePart, meta.SHA256)
 	if err != nil {
-		http.Error(w, "Failed to create project directory", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	dstPath := filepath.Join(projectDir, refs.ObjectTarName)
+	// This holds the lock for the whole upload; large pins should use
+	// the resumable uploads, which only lock to finalize.
+	unlock := s.pushLocks.lock(project)
+	defer unlock()
 
-	dst, err := os.Create(dstPath)
-	if err != nil {
-		http.Error(w, "Could not write file", http.StatusInternalServerError)
+	if !s.checkParent(w, project, meta.Version, meta.Parent) {
 		return
 	}
-	defer func() { _ = dst.Close() }()
 
-	_, err = dst.ReadFrom(file)
-	if err != nil {
-		http.Error(w, "Failed to write data", http.StatusInternalServerError)
+	if err := s.Store.PutPinFrom(project, meta.Version, meta.Parent, archive); err != nil {
+		switch {
+		case errors.Is(err, store.ErrExists):
+			head, _ := s.head(project)
+			writePushConflict(w, head, meta.Parent)
+		case errors.Is(err, store.ErrChecksumMismatch):
+			http.Error(w, "Upload corrupted: "+err.Error(), http.StatusUnprocessableEntity)
+		case errors.Is(err, index.ErrInvalidArchive):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			fmt.Printf("error storing pin: %v\n", err)
+			http.Error(w, "Failed to write data", http.StatusInternalServerError)
+		}
 		return
 	}
 
+	s.pinStored(project, meta.Version, meta.Parent, auth.UserFromContext(r.Context()))
+
 	w.WriteHeader(http.StatusCreated)
 	_, _ = w.Write([]byte("Pin uploaded"))
 }
@@ -269,17 +467,14 @@ func (s *Server) HandleUploadPin(w http.ResponseWriter, r *http.Request) {
 func (s *Server) HandleGetVersionList(w http.ResponseWriter, r *http.Request) {
 	project := chi.URLParam(r, "project")
 
-	versions, err := os.ReadDir(filepath.Join(s.DataDir, "projects", project, "objects"))
+	versionsList, err := s.Store.ListPins(project)
 	if err != nil {
-		http.Error(w, "Error accessing project", http.StatusInternalServerError)
-		return
-	}
-
-	var versionsList []string
-	for _, entry := range versions {
-		if entry.IsDir() {
-			versionsList = append(versionsList, entry.Name())
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Project not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Error accessing project", http.StatusInternalServerError)
 		}
+		return
 	}
 
 	sortVersionNumbers(versionsList)
@@ -287,28 +482,49 @@ func (s *Server) HandleGetVersionList(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(versionsList)
 }
 
+// Pins never change once stored, so downloads can be cached forever.
+// They are only served to authenticated users, hence private.
+const immutableCacheControl = "private, max-age=31536000, immutable"
+
+// pinETag identifies a stored pin. Including when it was stored keeps
+// the tag from matching a pin that was deleted and pushed again.
+func pinETag(version string, info store.PinInfo) string {
+	return fmt.Sprintf(`"%s-%s"`, version, strconv.FormatInt(info.Created.UnixNano(), 36))
+}
+
+func fileETag(version string, file string, info store.FileInfo) string {
+	sum := sha256.Sum256([]byte(file))
+	return fmt.Sprintf(`"%s-%s-%x"`, version, strconv.FormatInt(info.Pin.Created.UnixNano(), 36), sum[:8])
+}
+
+// HandleFetchVersion serves a pin archive, honoring Range and
+// conditional requests.
 func (s *Server) HandleFetchVersion(w http.ResponseWriter, r *http.Request) {
 	project := chi.URLParam(r, "project")
 	version := chi.URLParam(r, "version")
 
-	pinPath := filepath.Join(s.DataDir, "projects", project, "objects", version, refs.ObjectTarName)
-
-	file, err := os.Open(pinPath)
+	file, info, err := s.Store.OpenPin(project, version)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("version not found: %v", err), http.StatusNotFound)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Version not found", http.StatusNotFound)
+		} else {
+			fmt.Printf("error opening pin: %v\n", err)
+			http.Error(w, "Failed to read pin", http.StatusInternalServerError)
+		}
 		return
 	}
 	defer func() { _ = file.Close() }()
 
 	w.Header().Set("Content-Type", "application/gzip")
 	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar.gz"`, version))
+	w.Header().Set("ETag", pinETag(version, info))
+	w.Header().Set("Cache-Control", immutableCacheControl)
 
-	if _, err := io.Copy(w, file); err != nil {
-		http.Error(w, "failed to stream file", http.StatusInternalServerError)
-		return
-	}
+	http.ServeContent(w, r, "", info.Created, file)
 }
 
+// HandleFetchFile serves one file from a pin, honoring Range and
+// conditional requests so clients can seek inside audio.
 func (s *Server) HandleFetchFile(w http.ResponseWriter, r *http.Request) {
 	project := chi.URLParam(r, "project")
 	version := chi.URLParam(r, "version")
@@ -319,56 +535,29 @@ func (s *Server) HandleFetchFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tarPath := filepath.Join(s.DataDir, "projects", project, "objects", version, refs.ObjectTarName)
-
-	f, err := os.Open(tarPath)
+	f, etag, modified, err := s.openFileAt(project, version, filename)
 	if err != nil {
-		http.Error(w, "Failed to open archive: "+err.Error(), http.StatusInternalServerError)
+		writeOpenFileError(w, err)
 		return
 	}
 	defer func() { _ = f.Close() }()
 
-	gz, err := gzip.NewReader(f)
-	if err != nil {
-		http.Error(w, "Failed to read gzip: "+err.Error(), http.StatusInternalServerError)
-		return
+	contentType := mime.TypeByExtension(path.Ext(filename))
+	if contentType == "" {
+		contentType = "application/octet-stream"
 	}
-	defer func() { _ = gz.Close() }()
 
-	tr := tar.NewReader(gz)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "inline; filename=\""+path.Base(filename)+"\"")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", immutableCacheControl)
 
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			http.Error(w, "File not found in archive", http.StatusNotFound)
-			return
-		}
-		if err != nil {
-			http.Error(w, "Failed to read tar: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		if strings.HasPrefix(hdr.Name, "files/") && hdr.Name == "files/"+filename {
-			w.Header().Set("Content-Type", "application/octet-stream")
-			w.Header().Set("Content-Disposition", "inline; filename=\""+path.Base(filename)+"\"")
-
-			if _, err := io.Copy(w, tr); err != nil {
-				log.Printf("Failed to write file to response: %v", err)
-			}
-			return
-		}
-	}
+	http.ServeContent(w, r, "", modified, f)
 }
 
+// Anything in storage that is not a valid version sorts last.
 func sortVersionNumbers(versions []string) {
 	sort.Slice(versions, func(i, j int) bool {
-		mi := refs.ParseVersion(versions[i])
-		mj := refs.ParseVersion(versions[j])
-
-		if mi.Major != mj.Major {
-			return mi.Major < mj.Major
-		}
-
-		return mi.Minor < mj.Minor
+		return refs.VersionLess(versions[i], versions[j])
 	})
 }
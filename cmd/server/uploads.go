@@ -0,0 +1,253 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"stewdio/internal/auth"
+	"stewdio/internal/index"
+	"stewdio/internal/store"
+	"stewdio/internal/upload"
+	"stewdio/internal/validate"
+)
+
+// Resumable uploads: POST .../uploads creates a session, each chunk is
+// PUT to .../uploads/{upload}/chunks/{n} in order, GET .../uploads/{upload}
+// reports how much has arrived, and POST .../uploads/{upload}/finalize
+// checks the archive and stores it as a pin.
+
+type createUploadRequest struct {
+	Version string `json:"version"`
+	Parent  string `json:"parent"`
+	SHA256  string `json:"sha256"`
+	Size    int64  `json:"size"`
+}
+
+type uploadRes struct {
+	ID        string `json:"id"`
+	Version   string `json:"version"`
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunkSize"`
+}
+
+func newUploadRes(session upload.Session, offset int64) uploadRes {
+	return uploadRes{
+		ID:        session.ID,
+		Version:   session.Version,
+		Offset:    offset,
+		Size:      session.Size,
+		ChunkSize: session.ChunkSize,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) HandleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	project := chi.URLParam(r, "project")
+
+	var req createUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Version(req.Version); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sum, err := hex.DecodeString(req.SHA256); err != nil || len(sum) != 32 {
+		http.Error(w, "Missing or invalid sha256", http.StatusBadRequest)
+		return
+	}
+	if req.Size <= 0 {
+		http.Error(w, "Missing or invalid size", http.StatusBadRequest)
+		return
+	}
+	if req.Parent != "" {
+		if err := validate.Version(req.Parent); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Checked again when the upload is finalized, but there is no point
+	// sending the archive if it will be rejected.
+	if !s.checkParent(w, project, req.Version, req.Parent) {
+		return
+	}
+
+	if pin, _, err := s.Store.OpenPin(project, req.Version); err == nil {
+		_ = pin.Close()
+		head, _ := s.head(project)
+		writePushConflict(w, head, req.Parent)
+		return
+	}
+
+	session, err := s.Uploads.Create(upload.Session{
+		Project: project,
+		Version: req.Version,
+		Parent:  req.Parent,
+		User:    auth.UserFromContext(r.Context()),
+		SHA256:  req.SHA256,
+		Size:    req.Size,
+	})
+	if err != nil {
+		fmt.Printf("error creating upload: %v\n", err)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newUploadRes(session, 0))
+}
+
+// session looks up the upload named in the request, which only the user
+// who started it may touch.
+func (s *Server) session(w http.ResponseWriter, r *http.Request) (upload.Session, int64, bool) {
+	session, offset, err := s.Uploads.Get(chi.URLParam(r, "upload"))
+	if err == nil && (session.Project != chi.URLParam(r, "project") || session.User != auth.UserFromContext(r.Context())) {
+		err = upload.ErrNotFound
+	}
+
+	if err != nil {
+		if errors.Is(err, upload.ErrNotFound) {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+		} else {
+			fmt.Printf("error reading upload: %v\n", err)
+			http.Error(w, "Failed to read upload", http.StatusInternalServerError)
+		}
+		return session, 0, false
+	}
+
+	return session, offset, true
+}
+
+func (s *Server) HandleGetUpload(w http.ResponseWriter, r *http.Request) {
+	session, offset, ok := s.session(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newUploadRes(session, offset))
+}
+
+func (s *Server) HandleUploadChunk(w http.ResponseWriter, r *http.Request) {
+	session, _, ok := s.session(w, r)
+	if !ok {
+		return
+	}
+
+	n, err := strconv.ParseInt(chi.URLParam(r, "chunk"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid chunk number", http.StatusBadRequest)
+		return
+	}
+	if r.ContentLength < 0 {
+		http.Error(w, "Chunks need a Content-Length", http.StatusLengthRequired)
+		return
+	}
+
+	offset, err := s.Uploads.WriteChunk(session.ID, n, r.ContentLength, r.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, upload.ErrOutOfOrder):
+			// Tell the client where to carry on from.
+			writeJSON(w, http.StatusConflict, newUploadRes(session, offset))
+		case errors.Is(err, upload.ErrChunkSize):
+			http.Error(w, fmt.Sprintf("Chunk %d has the wrong size", n), http.StatusBadRequest)
+		case errors.Is(err, upload.ErrNotFound):
+			http.Error(w, "Upload not found", http.StatusNotFound)
+		default:
+			fmt.Printf("error writing chunk: %v\n", err)
+			http.Error(w, "Failed to write chunk", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newUploadRes(session, offset))
+}
+
+func (s *Server) HandleFinalizeUpload(w http.ResponseWriter, r *http.Request) {
+	session, offset, ok := s.session(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := s.Uploads.Open(session.ID)
+	if err != nil {
+		if errors.Is(err, upload.ErrIncomplete) {
+			writeJSON(w, http.StatusConflict, newUploadRes(session, offset))
+		} else {
+			fmt.Printf("error opening upload: %v\n", err)
+			http.Error(w, "Failed to read upload", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer func() { _ = data.Close() }()
+
+	archive, err := store.VerifyReader(data, session.SHA256)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	unlock := s.pushLocks.lock(session.Project)
+	defer unlock()
+
+	if !s.checkParent(w, session.Project, session.Version, session.Parent) {
+		_ = s.Uploads.Remove(session.ID)
+		return
+	}
+
+	err = s.Store.PutPinFrom(session.Project, session.Version, session.Parent, archive)
+	if err == nil || errors.Is(err, store.ErrExists) || errors.Is(err, store.ErrChecksumMismatch) ||
+		errors.Is(err, index.ErrInvalidArchive) {
+		// None of these can be fixed by sending the same data again.
+		_ = s.Uploads.Remove(session.ID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrExists):
+			head, _ := s.head(session.Project)
+			writePushConflict(w, head, session.Parent)
+		case errors.Is(err, store.ErrChecksumMismatch):
+			http.Error(w, "Upload corrupted: "+err.Error(), http.StatusUnprocessableEntity)
+		case errors.Is(err, index.ErrInvalidArchive):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			fmt.Printf("error storing pin: %v\n", err)
+			http.Error(w, "Failed to write data", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.pinStored(session.Project, session.Version, session.Parent, session.User)
+
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte("Pin uploaded"))
+}
+
+func (s *Server) HandleCancelUpload(w http.ResponseWriter, r *http.Request) {
+	session, _, ok := s.session(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.Uploads.Remove(session.ID); err != nil && !errors.Is(err, upload.ErrNotFound) {
+		fmt.Printf("error removing upload: %v\n", err)
+		http.Error(w, "Failed to cancel upload", http.StatusInternalServerError)
+		return
+	}
+
+	_, _ = w.Write([]byte("Upload cancelled"))
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"stewdio/internal/auth"
+	cmdUtils "stewdio/internal/cmd/utils"
+	"stewdio/internal/validate"
+)
+
+// Accounts live next to the data, whichever backend holds the pins.
+func openUsers(opts *ServerOpts) (*auth.Users, error) {
+	if err := os.MkdirAll(opts.DataLocation, 0o755); err != nil {
+		return nil, err
+	}
+	return auth.OpenUsers(filepath.Join(opts.DataLocation, "users.json"))
+}
+
+func userCmd(opts *ServerOpts) *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "user",
+		Short: "Manage accounts that can access the server",
+	}
+
+	cmd.AddCommand(userAddCmd(opts))
+	cmd.AddCommand(userTokenCmd(opts))
+	cmd.AddCommand(userGrantCmd(opts))
+	cmdUtils.SetHelpFlagText(&cmd)
+
+	return &cmd
+}
+
+func userAddCmd(opts *ServerOpts) *cobra.Command {
+	cmd := cobra.Command{
+		Use:          "add {NAME}",
+		Short:        "Create a user and print their first API token",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdUtils.CommandErrorHandler(userAddMain(opts, args[0]))
+		},
+	}
+
+	cmd.SetHelpTemplate(cmd.HelpTemplate() + `
+Arguments:
+  [NAME]   Name of the new user
+`)
+	cmdUtils.SetHelpFlagText(&cmd)
+
+	return &cmd
+}
+
+func userTokenCmd(opts *ServerOpts) *cobra.Command {
+	cmd := cobra.Command{
+		Use:          "token {NAME}",
+		Short:        "Issue another API token for an existing user",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdUtils.CommandErrorHandler(userTokenMain(opts, args[0]))
+		},
+	}
+
+	cmd.SetHelpTemplate(cmd.HelpTemplate() + `
+Arguments:
+  [NAME]   Name of the user
+`)
+	cmdUtils.SetHelpFlagText(&cmd)
+
+	return &cmd
+}
+
+// Projects created before roles existed have no members, so their
+// first owner has to be granted on the server itself.
+func userGrantCmd(opts *ServerOpts) *cobra.Command {
+	cmd := cobra.Command{
+		Use:          "grant {NAME} {PROJECT} {ROLE}",
+		Short:        "Give a user a role in a project",
+		SilenceUsage: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if err := cobra.ExactArgs(3)(cmd, args); err != nil {
+				return err
+			}
+
+			if err := validate.Name(args[1]); err != nil {
+				return err
+			}
+
+			_, err := auth.ParseRole(args[2])
+			return err
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdUtils.CommandErrorHandler(userGrantMain(opts, args[0], args[1], auth.Role(args[2])))
+		},
+	}
+
+	cmd.SetHelpTemplate(cmd.HelpTemplate() + `
+Arguments:
+  [NAME]      Name of the user
+  [PROJECT]   Project to grant access to
+  [ROLE]      owner, writer or reader
+`)
+	cmdUtils.SetHelpFlagText(&cmd)
+
+	return &cmd
+}
+
+func userAddMain(opts *ServerOpts, name string) error {
+	if err := validate.Name(name); err != nil {
+		fmt.Println("error:", err)
+		return err
+	}
+
+	users, err := openUsers(opts)
+	if err != nil {
+		fmt.Println("error: failed to load users:", err)
+		return err
+	}
+
+	if err := users.Add(name); err != nil {
+		if errors.Is(err, auth.ErrUserExists) {
+			fmt.Printf("error: user %s already exists\n", name)
+		} else {
+			fmt.Println("error: failed to add user:", err)
+		}
+		return err
+	}
+
+	fmt.Println("Created user", name)
+	return userTokenMain(opts, name)
+}
+
+func userTokenMain(opts *ServerOpts, name string) error {
+	users, err := openUsers(opts)
+	if err != nil {
+		fmt.Println("error: failed to load users:", err)
+		return err
+	}
+
+	token, err := users.NewToken(name)
+	if err != nil {
+		if errors.Is(err, auth.ErrNoUser) {
+			fmt.Printf("error: user %s does not exist\n", name)
+		} else {
+			fmt.Println("error: failed to create token:", err)
+		}
+		return err
+	}
+
+	fmt.Println("API token (shown only once):")
+	fmt.Println(token)
+	fmt.Println("Give it to \"stewdio login\" to use it.")
+
+	return nil
+}
+
+func userGrantMain(opts *ServerOpts, name string, project string, role auth.Role) error {
+	users, err := openUsers(opts)
+	if err != nil {
+		fmt.Println("error: failed to load users:", err)
+		return err
+	}
+
+	if err := users.SetRole(project, name, role); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrNoUser):
+			fmt.Printf("error: user %s does not exist\n", name)
+		case errors.Is(err, auth.ErrLastOwner):
+			fmt.Printf("error: %s is the only owner of %s\n", name, project)
+		default:
+			fmt.Println("error: failed to set role:", err)
+		}
+		return err
+	}
+
+	fmt.Printf("%s is now %s of %s\n", name, role, project)
+	return nil
+}
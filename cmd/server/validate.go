@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"stewdio/internal/validate"
+)
+
+// validateParams rejects requests whose path parameters or file query
+// could name anything other than what they claim to, before they reach
+// storage.
+func validateParams(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := chi.RouteContext(r.Context()).URLParams
+
+		for i, key := range params.Keys {
+			value := params.Values[i]
+
+			var err error
+			switch key {
+			case "project", "user":
+				err = validate.Name(value)
+			case "version":
+				err = validate.Version(value)
+			}
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if r.URL.Query().Has("file") {
+			if err := validate.FilePath(r.URL.Query().Get("file")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
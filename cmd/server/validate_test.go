@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"stewdio/internal/auth"
+	"stewdio/internal/index"
+	"stewdio/internal/peaks"
+	"stewdio/internal/preview"
+	"stewdio/internal/store"
+	"stewdio/internal/testutil"
+	"stewdio/internal/upload"
+	"stewdio/internal/validate"
+)
+
+// newTestServer sets up a server the way serverMain does, with its data
+// in dir/data and everything else in dir/state. Olive owns "song", which
+// has one pin, and Rita owns "keep". It returns the server and Olive's
+// token.
+func newTestServer(t *testing.T, dir string) (*Server, string) {
+	t.Helper()
+
+	dataDir := filepath.Join(dir, "data")
+	stateDir := filepath.Join(dir, "state")
+
+	backend, err := store.NewFSStore(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := index.Open(filepath.Join(stateDir, "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = idx.Close() })
+	if err := idx.Rebuild(backend); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := auth.OpenUsers(filepath.Join(stateDir, "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"olive", "rita"} {
+		if err := users.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, err := users.NewToken("olive")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uploads, err := upload.NewSessions(filepath.Join(stateDir, "uploads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peakCache, err := peaks.NewCache(filepath.Join(stateDir, "peaks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previews, err := preview.NewCache(filepath.Join(stateDir, "previews"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(index.NewStore(backend, idx), idx, users, uploads, peakCache, previews)
+
+	archive := testutil.Archive(t, map[string]string{"message": "first", "files/kick.wav": "kick"})
+	if err := s.Store.PutPin("song", "0.1", bytes.NewReader(archive)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Store.CreateProject("keep"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetRole("song", "olive", auth.RoleOwner); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetRole("keep", "rita", auth.RoleOwner); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, token
+}
+
+// FuzzHandlers sends every route the server has, with fuzzed names in
+// place of its parameters, and checks that nothing outside the data
+// directory is touched and that invalid names are never served.
+func FuzzHandlers(f *testing.F) {
+	seeds := [][3]string{
+		{"song", "0.1", "kick.wav"},
+		{"..", "0.1", "kick.wav"},
+		{"song", "..", "../../secret"},
+		{"song", "0.1", "/etc/passwd"},
+		{"../keep", "01.1", "files/../kick.wav"},
+		{"keep", "0.1", "kick.wav"},
+		{".", "0.1/..", "."},
+		{"", "", ""},
+	}
+	for _, seed := range seeds {
+		f.Add(seed[0], seed[1], seed[2])
+	}
+
+	f.Fuzz(func(t *testing.T, project string, version string, file string) {
+		dir := t.TempDir()
+		secret := filepath.Join(dir, "secret")
+		if err := os.WriteFile(secret, []byte("secret"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		s, token := newTestServer(t, dir)
+
+		var routes [][2]string
+		err := chi.Walk(s.Router, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+			routes = append(routes, [2]string{method, route})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Each value is sent both escaped and as it is, which is how a
+		// client could slip a ".." segment past the router.
+		escape := strings.NewReplacer(
+			"{project}", url.PathEscape(project),
+			"{user}", url.PathEscape(project),
+			"{version}", url.PathEscape(version),
+			"{upload}", url.PathEscape(version),
+			"{chunk}", "0",
+		)
+		raw := strings.NewReplacer(
+			"{project}", project,
+			"{user}", project,
+			"{version}", version,
+			"{upload}", version,
+			"{chunk}", "0",
+		)
+		query := "?file=" + url.QueryEscape(file)
+
+		for _, route := range routes {
+			method, pattern := route[0], route[1]
+
+			for i, target := range []string{escape.Replace(pattern), raw.Replace(pattern)} {
+				// Event streams stay open until their request is done.
+				ctx, cancel := context.WithCancel(context.Background())
+				if strings.HasSuffix(pattern, "/events") {
+					cancel()
+				}
+
+				req, err := http.NewRequestWithContext(ctx, method, "http://stewdio"+target+query, strings.NewReader(`{"role":"reader"}`))
+				if err != nil {
+					cancel()
+					continue
+				}
+				req.Header.Set("Authorization", "Bearer "+token)
+
+				rec := httptest.NewRecorder()
+				s.Router.ServeHTTP(rec, req)
+				cancel()
+
+				// Unescaped, a value with slashes in it can legitimately
+				// name a different route.
+				escaped := i == 0
+				if escaped && rec.Code < 300 && strings.Contains(pattern, "{project}") && validate.Name(project) != nil {
+					t.Errorf("%s %s: invalid project %q served with %d", method, pattern, project, rec.Code)
+				}
+			}
+		}
+
+		if data, err := os.ReadFile(secret); err != nil || string(data) != "secret" {
+			t.Fatalf("file outside the data directory was changed: %v", err)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if name := entry.Name(); name != "data" && name != "state" && name != "secret" {
+				t.Errorf("%q was created outside the data directory", name)
+			}
+		}
+		if _, err := s.Store.GetProject("keep"); err != nil {
+			t.Errorf("a project Olive has no role in was touched: %v", err)
+		}
+	})
+}
@@ -0,0 +1,239 @@
+package watch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	cmdUtils "stewdio/internal/cmd/utils"
+	"stewdio/internal/config"
+	"stewdio/internal/events"
+	"stewdio/internal/utils"
+)
+
+const (
+	retryDelay    = time.Second
+	maxRetryDelay = 30 * time.Second
+)
+
+type watchOpts struct {
+	JSON bool
+	Exec string
+}
+
+func WatchRemoteCmd() *cobra.Command {
+	opts := watchOpts{}
+
+	cmd := cobra.Command{
+		Use:          "watch-remote",
+		Short:        "Follow what happens to the current project on its remote",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdUtils.CommandErrorHandler(watchMain(&opts))
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.JSON, "json", false, "Print each event as a line of JSON")
+	cmd.Flags().StringVarP(&opts.Exec, "exec", "e", "", "Shell command to run for each event")
+
+	cmd.SetHelpTemplate(cmd.HelpTemplate() + `
+Commands given to --exec receive the event as JSON on stdin, and in the
+STEWDIO_EVENT, STEWDIO_EVENT_ID, STEWDIO_PROJECT, STEWDIO_VERSION,
+STEWDIO_PARENT and STEWDIO_USER environment variables.
+`)
+	cmdUtils.SetHelpFlagText(&cmd)
+
+	return &cmd
+}
+
+// errStop ends watching without reconnecting.
+type errStop struct {
+	err error
+}
+
+func (e *errStop) Error() string {
+	return e.err.Error()
+}
+
+func watchMain(opts *watchOpts) error {
+	cwd, _ := os.Getwd()
+	if !utils.PathExists(filepath.Join(cwd, ".stew")) {
+		msg := "error: current directory is not a stewdio project"
+		fmt.Println(msg)
+		return fmt.Errorf("%s", msg)
+	}
+
+	cfg, err := config.ParseConfig(cwd)
+	if err != nil {
+		fmt.Println("error: failed to parse config:", err)
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v1/projects/%s/events", strings.TrimRight(cfg.Remote.Server, "/"), url.PathEscape(cfg.Remote.Project))
+
+	w := &watcher{opts: opts, server: cfg.Remote.Server, endpoint: endpoint}
+	fmt.Fprintf(os.Stderr, "Watching %s on %s\n", cfg.Remote.Project, cfg.Remote.Server)
+
+	delay := retryDelay
+	for {
+		connected, err := w.stream()
+
+		var stop *errStop
+		if errors.As(err, &stop) {
+			fmt.Println("error:", stop.err)
+			return stop.err
+		}
+		if err == nil {
+			// The project was deleted.
+			return nil
+		}
+
+		if connected {
+			delay = retryDelay
+		}
+		fmt.Fprintf(os.Stderr, "lost connection: %v, retrying in %v\n", err, delay)
+		time.Sleep(delay)
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+type watcher struct {
+	opts     *watchOpts
+	server   string
+	endpoint string
+	// Last event seen, so none are missed across reconnects
+	lastID string
+}
+
+// stream reads events until the connection drops, returning whether it
+// got as far as connecting. It returns nil once the project is deleted.
+func (w *watcher) stream() (bool, error) {
+	req, err := http.NewRequest("GET", w.endpoint, nil)
+	if err != nil {
+		return false, &errStop{fmt.Errorf("failed to create request: %w", err)}
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if w.lastID != "" {
+		req.Header.Set("Last-Event-ID", w.lastID)
+	}
+	if err := config.Authorize(req, w.server); err != nil {
+		return false, &errStop{err}
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized:
+		return false, &errStop{fmt.Errorf("not logged in to %s, run \"stewdio login\"", w.server)}
+	case res.StatusCode >= 500:
+		return false, fmt.Errorf("server error: %s", res.Status)
+	case res.StatusCode != http.StatusOK:
+		msg, _ := io.ReadAll(res.Body)
+		return false, &errStop{fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(msg)))}
+	}
+
+	// Server-Sent Events: fields up to a blank line make up an event.
+	var id, data string
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line != "" {
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "id":
+				id = value
+			case "data":
+				if data != "" {
+					data += "\n"
+				}
+				data += value
+			}
+			continue
+		}
+
+		if data == "" {
+			continue
+		}
+
+		var e events.Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			fmt.Fprintf(os.Stderr, "ignoring invalid event: %v\n", err)
+		} else {
+			w.handle(e, []byte(data))
+		}
+		if id != "" {
+			w.lastID = id
+		}
+		id, data = "", ""
+
+		if e.Type == events.ProjectDeleted {
+			return true, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, io.ErrUnexpectedEOF
+}
+
+func (w *watcher) handle(e events.Event, raw []byte) {
+	if w.opts.JSON {
+		fmt.Println(string(raw))
+	} else {
+		fmt.Printf("%s  %s\n", e.Time.Local().Format(time.DateTime), describe(e))
+	}
+
+	if w.opts.Exec == "" {
+		return
+	}
+
+	cmd := exec.Command("sh", "-c", w.opts.Exec)
+	cmd.Stdin = bytes.NewReader(raw)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"STEWDIO_EVENT="+e.Type,
+		"STEWDIO_PROJECT="+e.Project,
+		"STEWDIO_VERSION="+e.Version,
+		"STEWDIO_PARENT="+e.Parent,
+		"STEWDIO_USER="+e.User,
+		"STEWDIO_EVENT_ID="+strconv.FormatUint(e.ID, 10),
+	)
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: command failed for event %d: %v\n", e.ID, err)
+	}
+}
+
+func describe(e events.Event) string {
+	switch e.Type {
+	case events.PinCreated:
+		return fmt.Sprintf("%s pinned %s", e.User, e.Version)
+	case events.ProjectCreated:
+		return fmt.Sprintf("%s created %s", e.User, e.Project)
+	case events.ProjectDeleted:
+		return fmt.Sprintf("%s deleted %s", e.User, e.Project)
+	default:
+		return fmt.Sprintf("%s: %s", e.Type, e.Project)
+	}
+}
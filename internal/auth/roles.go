@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Role is what a user may do in a project. Each role can do everything
+// the ones below it can.
+type Role string
+
+const (
+	// Reader can list and fetch pins
+	RoleReader Role = "reader"
+	// Writer can also upload pins
+	RoleWriter Role = "writer"
+	// Owner can also delete the project and manage its members
+	RoleOwner Role = "owner"
+)
+
+var ErrLastOwner = errors.New("a project needs at least one owner")
+
+func ParseRole(s string) (Role, error) {
+	switch r := Role(s); r {
+	case RoleReader, RoleWriter, RoleOwner:
+		return r, nil
+	}
+	return "", fmt.Errorf("invalid role %q, expected owner, writer or reader", s)
+}
+
+func (r Role) rank() int {
+	switch r {
+	case RoleReader:
+		return 1
+	case RoleWriter:
+		return 2
+	case RoleOwner:
+		return 3
+	}
+	return 0
+}
+
+// Allows reports whether r includes everything min can do.
+func (r Role) Allows(min Role) bool {
+	return r.rank() >= min.rank() && r.rank() > 0
+}
+
+// Role returns a user's role in a project, or "" if they have none.
+func (u *Users) Role(project string, user string) (Role, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.reload(); err != nil {
+		return "", err
+	}
+	return u.projects[project][user], nil
+}
+
+// Members returns every user with a role in a project.
+func (u *Users) Members(project string) (map[string]Role, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.reload(); err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]Role, len(u.projects[project]))
+	for user, role := range u.projects[project] {
+		members[user] = role
+	}
+	return members, nil
+}
+
+// SetRole gives a user a role in a project, replacing any they had.
+func (u *Users) SetRole(project string, user string, role Role) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.reload(); err != nil {
+		return err
+	}
+	if _, ok := u.users[user]; !ok {
+		return ErrNoUser
+	}
+
+	members := u.projects[project]
+	if members == nil {
+		members = map[string]Role{}
+		u.projects[project] = members
+	}
+
+	if members[user] == RoleOwner && role != RoleOwner && u.ownerCount(project) == 1 {
+		return ErrLastOwner
+	}
+
+	members[user] = role
+	return u.save()
+}
+
+// RemoveMember takes away a user's role in a project.
+func (u *Users) RemoveMember(project string, user string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.reload(); err != nil {
+		return err
+	}
+
+	role, ok := u.projects[project][user]
+	if !ok {
+		return ErrNoUser
+	}
+	if role == RoleOwner && u.ownerCount(project) == 1 {
+		return ErrLastOwner
+	}
+
+	delete(u.projects[project], user)
+	return u.save()
+}
+
+// DeleteProject forgets every role in a project.
+func (u *Users) DeleteProject(project string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.reload(); err != nil {
+		return err
+	}
+	if _, ok := u.projects[project]; !ok {
+		return nil
+	}
+
+	delete(u.projects, project)
+	return u.save()
+}
+
+func (u *Users) ownerCount(project string) int {
+	count := 0
+	for _, role := range u.projects[project] {
+		if role == RoleOwner {
+			count++
+		}
+	}
+	return count
+}
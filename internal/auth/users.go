@@ -0,0 +1,261 @@
+// Package auth keeps the sync server's user accounts and the bearer
+// tokens they authenticate with.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"stewdio/internal/utils"
+)
+
+var (
+	ErrNoUser       = errors.New("no such user")
+	ErrUserExists   = errors.New("user already exists")
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Tokens are handed out once and only their hashes are kept.
+const tokenPrefix = "stw_"
+
+type User struct {
+	Name   string  `json:"name"`
+	Tokens []Token `json:"tokens"`
+}
+
+type Token struct {
+	Hash    string    `json:"hash"`
+	Created time.Time `json:"created"`
+}
+
+// Users is a registry of accounts and their project roles, saved as a
+// JSON file. The file is re-read whenever it changes, so accounts added
+// by another process (such as "stewdio server user add") take effect
+// without a restart.
+type Users struct {
+	Path string
+
+	mu       sync.Mutex
+	users    map[string]*User
+	byToken  map[string]string
+	projects map[string]map[string]Role
+	modTime  time.Time
+}
+
+type usersFile struct {
+	Users []*User `json:"users"`
+	// Project name to user name to role
+	Projects map[string]map[string]Role `json:"projects"`
+}
+
+func OpenUsers(path string) (*Users, error) {
+	u := &Users{Path: path}
+	if err := u.reload(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// reload reads the file again if it changed since it was last read.
+// The caller must hold mu, except in OpenUsers.
+func (u *Users) reload() error {
+	info, err := os.Stat(u.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		u.setUsers(usersFile{})
+		u.modTime = time.Time{}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if u.users != nil && info.ModTime().Equal(u.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(u.Path)
+	if err != nil {
+		return err
+	}
+
+	var f usersFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("invalid users file %s: %w", u.Path, err)
+	}
+
+	u.setUsers(f)
+	u.modTime = info.ModTime()
+
+	return nil
+}
+
+func (u *Users) setUsers(f usersFile) {
+	u.users = make(map[string]*User, len(f.Users))
+	u.byToken = make(map[string]string)
+	u.projects = f.Projects
+	if u.projects == nil {
+		u.projects = map[string]map[string]Role{}
+	}
+	for _, user := range f.Users {
+		u.users[user.Name] = user
+		for _, token := range user.Tokens {
+			u.byToken[token.Hash] = user.Name
+		}
+	}
+}
+
+func (u *Users) save() error {
+	f := usersFile{Users: []*User{}, Projects: u.projects}
+	for _, user := range u.users {
+		f.Users = append(f.Users, user)
+	}
+	sort.Slice(f.Users, func(i, j int) bool {
+		return f.Users[i].Name < f.Users[j].Name
+	})
+
+	err := utils.WriteFileAtomic(u.Path, 0o600, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(f)
+	})
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(u.Path); err == nil {
+		u.modTime = info.ModTime()
+	}
+	return nil
+}
+
+func (u *Users) Count() (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.reload(); err != nil {
+		return 0, err
+	}
+	return len(u.users), nil
+}
+
+func (u *Users) Exists(name string) (bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.reload(); err != nil {
+		return false, err
+	}
+	_, ok := u.users[name]
+	return ok, nil
+}
+
+func (u *Users) Add(name string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.reload(); err != nil {
+		return err
+	}
+	if _, ok := u.users[name]; ok {
+		return ErrUserExists
+	}
+
+	u.users[name] = &User{Name: name, Tokens: []Token{}}
+	return u.save()
+}
+
+// NewToken issues a token for a user. The token itself is only ever
+// returned here.
+func (u *Users) NewToken(name string) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.reload(); err != nil {
+		return "", err
+	}
+	user, ok := u.users[name]
+	if !ok {
+		return "", ErrNoUser
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	token := tokenPrefix + hex.EncodeToString(secret)
+
+	hash := hashToken(token)
+	user.Tokens = append(user.Tokens, Token{Hash: hash, Created: time.Now().UTC()})
+	u.byToken[hash] = name
+
+	if err := u.save(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Authenticate returns the name of the user a token belongs to.
+func (u *Users) Authenticate(token string) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err := u.reload(); err != nil {
+		return "", err
+	}
+
+	name, ok := u.byToken[hashToken(token)]
+	if !ok {
+		return "", ErrInvalidToken
+	}
+	return name, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type contextKey struct{}
+
+// UserFromContext returns the user authenticated by Middleware.
+func UserFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(contextKey{}).(string)
+	return name
+}
+
+// Middleware rejects requests without a valid bearer token.
+func (u *Users) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="stewdio"`)
+			http.Error(w, "Missing bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		name, err := u.Authenticate(strings.TrimSpace(token))
+		if err != nil {
+			if !errors.Is(err, ErrInvalidToken) {
+				fmt.Printf("error authenticating request: %v\n", err)
+				http.Error(w, "Unable to authenticate", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="stewdio", error="invalid_token"`)
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, name)))
+	})
+}
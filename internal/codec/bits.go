@@ -0,0 +1,142 @@
+package codec
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrTruncated = errors.New("encoded payload is truncated")
+
+// bitWriter packs values MSB-first into a byte slice.
+type bitWriter struct {
+	buf []byte
+	acc uint64
+	n   uint
+}
+
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	if n > 32 {
+		w.writeBits(v>>32, n-32)
+		n = 32
+	}
+	if n == 0 {
+		return
+	}
+
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.n += n
+
+	for w.n >= 8 {
+		w.buf = append(w.buf, byte(w.acc>>(w.n-8)))
+		w.n -= 8
+	}
+}
+
+func (w *bitWriter) writeSigned(v int64, n uint) {
+	w.writeBits(uint64(v), n)
+}
+
+func (w *bitWriter) writeUnary(q uint64) {
+	for ; q >= 32; q -= 32 {
+		w.writeBits(0, 32)
+	}
+	w.writeBits(1, uint(q)+1)
+}
+
+// bytes flushes any partial byte, padding it with zero bits.
+func (w *bitWriter) bytes() []byte {
+	if w.n > 0 {
+		w.buf = append(w.buf, byte(w.acc<<(8-w.n)))
+		w.acc = 0
+		w.n = 0
+	}
+	return w.buf
+}
+
+// bitReader reads values packed by bitWriter, from buf or, when src is
+// set, from src.
+type bitReader struct {
+	buf []byte
+	pos int
+	src io.ByteReader
+	acc uint64
+	n   uint
+	err error
+}
+
+func (r *bitReader) readBits(n uint) uint64 {
+	if n > 32 {
+		hi := r.readBits(n - 32)
+		return hi<<32 | r.readBits(32)
+	}
+
+	for r.n < n {
+		b := r.readByte()
+		if r.err != nil {
+			return 0
+		}
+		r.acc = r.acc<<8 | uint64(b)
+		r.n += 8
+	}
+
+	r.n -= n
+	return r.acc >> r.n & (1<<n - 1)
+}
+
+// readByte reads the byte following the current (byte-aligned) position.
+func (r *bitReader) readByte() byte {
+	if r.src != nil {
+		b, err := r.src.ReadByte()
+		if err == io.EOF {
+			err = ErrTruncated
+		}
+		if err != nil {
+			r.err = err
+		}
+		return b
+	}
+
+	if r.pos >= len(r.buf) {
+		r.err = ErrTruncated
+		return 0
+	}
+	r.pos++
+	return r.buf[r.pos-1]
+}
+
+// more reports whether any bytes follow the current position.
+func (r *bitReader) more() bool {
+	if r.src != nil {
+		if _, err := r.src.ReadByte(); err != nil {
+			return false
+		}
+		return true
+	}
+	return r.pos < len(r.buf)
+}
+
+func (r *bitReader) readSigned(n uint) int64 {
+	v := r.readBits(n)
+	if n == 0 {
+		return 0
+	}
+	// Sign-extend from n bits.
+	shift := 64 - n
+	return int64(v<<shift) >> shift
+}
+
+// readUnary counts zero bits up to the next set bit. It gives up once
+// limit zeros have been seen, reporting false.
+func (r *bitReader) readUnary(limit uint64) (uint64, bool) {
+	var q uint64
+	for q < limit {
+		if r.readBits(1) == 1 {
+			return q, true
+		}
+		if r.err != nil {
+			return q, false
+		}
+		q++
+	}
+	return q, false
+}
@@ -0,0 +1,460 @@
+// Package codec implements a lossless coder for PCM sample payloads.
+//
+// Samples are split into blocks and per channel. Each channel of a block
+// is either stored verbatim or modeled with a fixed polynomial or
+// quantized LPC predictor, and the prediction residual is Rice coded,
+// following the same scheme as FLAC subframes.
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Magic marks the start of an encoded payload.
+var Magic = []byte("STWC")
+
+const (
+	formatVersion = 1
+	blockSize     = 4096
+
+	// Largest decoded payload accepted, so a corrupt length cannot ask
+	// for an absurd allocation
+	maxLength = 1<<31 - 1
+
+	flagFloat = 1 << 0
+)
+
+const (
+	subframeVerbatim = iota
+	subframeFixed
+	subframeLPC
+
+	subframeTypeBits = 2
+)
+
+var (
+	ErrNotEncoded   = errors.New("payload is not codec-encoded")
+	ErrTrailingData = errors.New("encoded payload is followed by trailing data")
+)
+
+type Header struct {
+	BitDepth int
+	Channels int
+	Float    bool
+	// Length of the decoded PCM payload in bytes
+	Length int
+}
+
+func (h Header) sampleBytes() int {
+	return h.BitDepth / 8
+}
+
+func (h Header) frameBytes() int {
+	return h.sampleBytes() * h.Channels
+}
+
+func IsEncoded(data []byte) bool {
+	return bytes.HasPrefix(data, Magic)
+}
+
+// Encode compresses little-endian interleaved PCM. Trailing bytes that
+// do not make up a whole frame are stored as-is.
+func Encode(pcm []byte, bitDepth int, channels int, float bool) ([]byte, error) {
+	switch bitDepth {
+	case 8, 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("unsupported bit depth: %d", bitDepth)
+	}
+	if channels < 1 || channels > 255 {
+		return nil, fmt.Errorf("unsupported channel count: %d", channels)
+	}
+
+	hdr := Header{
+		BitDepth: bitDepth,
+		Channels: channels,
+		Float:    float,
+		Length:   len(pcm),
+	}
+
+	out := writeHeader(hdr)
+
+	frames := len(pcm) / hdr.frameBytes()
+
+	w := bitWriter{buf: out}
+	channel := make([]int64, 0, blockSize)
+	scratch := make([]int64, 0, blockSize)
+
+	for start := 0; start < frames; start += blockSize {
+		n := min(blockSize, frames-start)
+		for ch := range channels {
+			channel = channel[:0]
+			for i := range n {
+				off := (start+i)*hdr.frameBytes() + ch*hdr.sampleBytes()
+				channel = append(channel, UnpackSample(pcm[off:], bitDepth))
+			}
+			scratch = encodeSubframe(&w, channel, uint(bitDepth), scratch)
+		}
+	}
+
+	out = w.bytes()
+	out = append(out, pcm[frames*hdr.frameBytes():]...)
+
+	return out, nil
+}
+
+// Decode reverses Encode, returning the original PCM bytes.
+func Decode(data []byte) ([]byte, Header, error) {
+	hdr, body, err := readHeader(data)
+	if err != nil {
+		return nil, hdr, err
+	}
+
+	pcm := bytes.NewBuffer(make([]byte, 0, hdr.Length))
+	if _, err := pcm.ReadFrom(newReader(hdr, bitReader{buf: body})); err != nil {
+		return nil, hdr, err
+	}
+
+	return pcm.Bytes(), hdr, nil
+}
+
+// Reader decodes a payload one block at a time, so only a block of
+// samples is held in memory however long the payload is.
+type Reader struct {
+	hdr Header
+	r   bitReader
+
+	// Frames decoded so far
+	frame    int
+	channel  []int64
+	residual []int64
+
+	block   []byte
+	pending []byte
+	done    bool
+}
+
+// NewReader reads the header of an encoded payload from r and returns a
+// Reader for the PCM bytes it holds.
+func NewReader(r io.Reader) (*Reader, error) {
+	src, ok := r.(byteReader)
+	if !ok {
+		src = bufio.NewReader(r)
+	}
+
+	hdr, err := decodeHeader(src)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReader(hdr, bitReader{src: src}), nil
+}
+
+func newReader(hdr Header, r bitReader) *Reader {
+	return &Reader{
+		hdr:      hdr,
+		r:        r,
+		channel:  make([]int64, blockSize),
+		residual: make([]int64, blockSize),
+		block:    make([]byte, blockSize*hdr.frameBytes()),
+	}
+}
+
+func (d *Reader) Header() Header {
+	return d.hdr
+}
+
+func (d *Reader) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+// next decodes the following block into pending, or once every frame
+// is out, the trailing bytes that do not make up a whole frame.
+func (d *Reader) next() error {
+	frameBytes := d.hdr.frameBytes()
+	frames := d.hdr.Length / frameBytes
+
+	if d.frame < frames {
+		n := min(blockSize, frames-d.frame)
+		for ch := range d.hdr.Channels {
+			decodeSubframe(&d.r, d.channel[:n], d.residual, uint(d.hdr.BitDepth))
+			if d.r.err != nil {
+				return d.r.err
+			}
+
+			for i, s := range d.channel[:n] {
+				PackSample(d.block[i*frameBytes+ch*d.hdr.sampleBytes():], s, d.hdr.BitDepth)
+			}
+		}
+
+		d.frame += n
+		d.pending = d.block[:n*frameBytes]
+		return nil
+	}
+
+	tail := d.block[:d.hdr.Length-frames*frameBytes]
+	for i := range tail {
+		tail[i] = d.r.readByte()
+	}
+	if d.r.err != nil {
+		return d.r.err
+	}
+	if d.r.more() {
+		return ErrTrailingData
+	}
+
+	d.done = true
+	d.pending = tail
+	return nil
+}
+
+func writeHeader(hdr Header) []byte {
+	out := append([]byte{}, Magic...)
+
+	var flags byte
+	if hdr.Float {
+		flags |= flagFloat
+	}
+	out = append(out, formatVersion, byte(hdr.BitDepth), byte(hdr.Channels), flags)
+	out = binary.AppendUvarint(out, uint64(hdr.Length))
+
+	return out
+}
+
+func readHeader(data []byte) (Header, []byte, error) {
+	r := bytes.NewReader(data)
+	hdr, err := decodeHeader(r)
+	if err != nil {
+		return hdr, nil, err
+	}
+	body := data[len(data)-r.Len():]
+
+	// Every sample takes at least one bit to code and trailing bytes are
+	// stored as-is, so a body this short cannot hold that much.
+	frames := hdr.Length / hdr.frameBytes()
+	tail := hdr.Length - frames*hdr.frameBytes()
+	if uint64(frames)*uint64(hdr.Channels) > 8*uint64(len(body)) || tail > len(body) {
+		return hdr, nil, ErrTruncated
+	}
+
+	return hdr, body, nil
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func decodeHeader(r byteReader) (Header, error) {
+	var hdr Header
+
+	data := make([]byte, len(Magic)+4)
+	n, err := io.ReadFull(r, data)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return hdr, err
+	}
+	if !IsEncoded(data[:n]) {
+		return hdr, ErrNotEncoded
+	}
+	if err != nil {
+		return hdr, ErrTruncated
+	}
+	data = data[len(Magic):]
+
+	if data[0] != formatVersion {
+		return hdr, fmt.Errorf("unsupported codec version: %d", data[0])
+	}
+
+	hdr.BitDepth = int(data[1])
+	hdr.Channels = int(data[2])
+	hdr.Float = data[3]&flagFloat != 0
+
+	switch hdr.BitDepth {
+	case 8, 16, 24, 32:
+	default:
+		return hdr, fmt.Errorf("unsupported bit depth: %d", hdr.BitDepth)
+	}
+	if hdr.Channels < 1 {
+		return hdr, fmt.Errorf("invalid channel count: %d", hdr.Channels)
+	}
+
+	length, err := binary.ReadUvarint(r)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return hdr, ErrTruncated
+	} else if err != nil {
+		return hdr, err
+	}
+	if length > maxLength {
+		return hdr, fmt.Errorf("decoded length %d exceeds limit", length)
+	}
+	hdr.Length = int(length)
+
+	return hdr, nil
+}
+
+func encodeSubframe(w *bitWriter, samples []int64, bitDepth uint, scratch []int64) []int64 {
+	candidates := make([]predictor, 0, len(fixedCoefs)+4)
+	for _, coefs := range fixedCoefs {
+		if len(coefs) < len(samples) {
+			candidates = append(candidates, predictor{kind: subframeFixed, coefs: coefs})
+		}
+	}
+	candidates = append(candidates, lpcCandidates(samples)...)
+
+	verbatimCost := uint64(len(samples)) * uint64(bitDepth)
+
+	var (
+		best       predictor
+		bestLayout riceLayout
+		bestValues []uint64
+		bestCost   = verbatimCost
+	)
+
+	for _, p := range candidates {
+		scratch = p.residual(samples, scratch)
+
+		values := make([]uint64, len(scratch))
+		for i, r := range scratch {
+			values[i] = zigzag(r)
+		}
+
+		layout := planResidual(values)
+		cost := p.headerCost(bitDepth) + layout.cost
+		if cost < bestCost {
+			best, bestLayout, bestValues, bestCost = p, layout, values, cost
+		}
+	}
+
+	if bestValues == nil {
+		w.writeBits(subframeVerbatim, subframeTypeBits)
+		for _, s := range samples {
+			w.writeSigned(s, bitDepth)
+		}
+		return scratch
+	}
+
+	w.writeBits(uint64(best.kind), subframeTypeBits)
+	switch best.kind {
+	case subframeFixed:
+		w.writeBits(uint64(best.order()), fixedOrderBits)
+	case subframeLPC:
+		w.writeBits(uint64(best.order()-1), lpcOrderBits)
+		w.writeBits(uint64(best.precision), precisionBits)
+		w.writeBits(uint64(best.shift), shiftBits)
+		for _, c := range best.coefs {
+			w.writeSigned(c, best.precision)
+		}
+	}
+
+	for _, s := range samples[:best.order()] {
+		w.writeSigned(s, bitDepth)
+	}
+	writeResidual(w, bestValues, bestLayout)
+
+	return scratch
+}
+
+func (p predictor) headerCost(bitDepth uint) uint64 {
+	cost := uint64(subframeTypeBits) + uint64(p.order())*uint64(bitDepth)
+	switch p.kind {
+	case subframeFixed:
+		cost += fixedOrderBits
+	case subframeLPC:
+		cost += lpcOrderBits + precisionBits + shiftBits + uint64(p.order())*uint64(p.precision)
+	}
+	return cost
+}
+
+func decodeSubframe(r *bitReader, samples []int64, residual []int64, bitDepth uint) {
+	var p predictor
+
+	switch r.readBits(subframeTypeBits) {
+	case subframeVerbatim:
+		for i := range samples {
+			samples[i] = r.readSigned(bitDepth)
+		}
+		return
+	case subframeFixed:
+		order := r.readBits(fixedOrderBits)
+		if order >= uint64(len(fixedCoefs)) {
+			r.err = errors.New("invalid fixed predictor order")
+			return
+		}
+		p = predictor{kind: subframeFixed, coefs: fixedCoefs[order]}
+	case subframeLPC:
+		order := int(r.readBits(lpcOrderBits)) + 1
+		p = predictor{
+			kind:      subframeLPC,
+			coefs:     make([]int64, order),
+			precision: uint(r.readBits(precisionBits)),
+			shift:     uint(r.readBits(shiftBits)),
+		}
+		for i := range p.coefs {
+			p.coefs[i] = r.readSigned(p.precision)
+		}
+	default:
+		r.err = errors.New("invalid subframe type")
+		return
+	}
+
+	if p.order() > len(samples) {
+		r.err = errors.New("predictor order exceeds block size")
+		return
+	}
+
+	for i := range p.order() {
+		samples[i] = r.readSigned(bitDepth)
+	}
+
+	residual = residual[:len(samples)-p.order()]
+	readResidual(r, residual)
+	p.restore(samples, residual)
+}
+
+// UnpackSample reads one little-endian sample as a signed integer.
+// 32-bit float samples come back as their raw bit pattern.
+func UnpackSample(b []byte, bitDepth int) int64 {
+	switch bitDepth {
+	case 8:
+		// 8-bit WAV samples are unsigned
+		return int64(b[0]) - 128
+	case 16:
+		return int64(int16(binary.LittleEndian.Uint16(b)))
+	case 24:
+		v := int32(b[0]) | int32(b[1])<<8 | int32(b[2])<<16
+		return int64(v<<8) >> 8
+	default:
+		return int64(int32(binary.LittleEndian.Uint32(b)))
+	}
+}
+
+// PackSample is the inverse of UnpackSample.
+func PackSample(b []byte, v int64, bitDepth int) {
+	switch bitDepth {
+	case 8:
+		b[0] = byte(v + 128)
+	case 16:
+		binary.LittleEndian.PutUint16(b, uint16(v))
+	case 24:
+		b[0] = byte(v)
+		b[1] = byte(v >> 8)
+		b[2] = byte(v >> 16)
+	default:
+		binary.LittleEndian.PutUint32(b, uint32(v))
+	}
+}
@@ -0,0 +1,247 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"testing"
+	"testing/iotest"
+)
+
+// music makes PCM that behaves like a recording: a few harmonics with a
+// slow swell and a little noise, so predictors have something to find.
+func music(frames int, bitDepth int, channels int, float bool) []byte {
+	rng := rand.New(rand.NewPCG(1, 2))
+	sampleBytes := bitDepth / 8
+	pcm := make([]byte, frames*channels*sampleBytes)
+
+	for i := range frames {
+		t := float64(i) / 44100
+		swell := 0.5 + 0.4*math.Sin(2*math.Pi*0.5*t)
+		for ch := range channels {
+			v := swell * (0.5*math.Sin(2*math.Pi*220*t+float64(ch)) +
+				0.25*math.Sin(2*math.Pi*440*t) +
+				0.1*math.Sin(2*math.Pi*1320*t))
+			v += (rng.Float64() - 0.5) * 0.002
+
+			off := (i*channels + ch) * sampleBytes
+			if float {
+				binary.LittleEndian.PutUint32(pcm[off:], math.Float32bits(float32(v)))
+				continue
+			}
+			peak := float64(int64(1)<<(bitDepth-1) - 1)
+			PackSample(pcm[off:], int64(v*peak), bitDepth)
+		}
+	}
+	return pcm
+}
+
+func TestRoundTrip(t *testing.T) {
+	formats := []struct {
+		name     string
+		bitDepth int
+		float    bool
+	}{
+		{"8-bit", 8, false},
+		{"16-bit", 16, false},
+		{"24-bit", 24, false},
+		{"32-bit", 32, false},
+		{"32-bit float", 32, true},
+	}
+	lengths := []struct {
+		name   string
+		frames int
+		// Bytes after the last whole frame
+		extra int
+	}{
+		{"empty", 0, 0},
+		{"one frame", 1, 0},
+		{"shorter than a predictor", 3, 0},
+		{"one block", blockSize, 0},
+		{"partial trailing block", 2*blockSize + 1234, 0},
+		{"partial trailing frame", blockSize + 17, 1},
+	}
+
+	for _, format := range formats {
+		for _, channels := range []int{1, 2, 3, 6} {
+			for _, length := range lengths {
+				pcm := music(length.frames, format.bitDepth, channels, format.float)
+				pcm = append(pcm, bytes.Repeat([]byte{0x5a}, length.extra)...)
+
+				encoded, err := Encode(pcm, format.bitDepth, channels, format.float)
+				if err != nil {
+					t.Fatalf("%s, %d channels, %s: encode: %v", format.name, channels, length.name, err)
+				}
+				decoded, hdr, err := Decode(encoded)
+				if err != nil {
+					t.Fatalf("%s, %d channels, %s: decode: %v", format.name, channels, length.name, err)
+				}
+
+				if !bytes.Equal(decoded, pcm) {
+					t.Errorf("%s, %d channels, %s: decoded PCM differs from the input", format.name, channels, length.name)
+				}
+				want := Header{BitDepth: format.bitDepth, Channels: channels, Float: format.float, Length: len(pcm)}
+				if hdr != want {
+					t.Errorf("%s, %d channels, %s: header %+v, expected %+v", format.name, channels, length.name, hdr, want)
+				}
+			}
+		}
+	}
+}
+
+func TestRoundTripExtremes(t *testing.T) {
+	for _, bitDepth := range []int{8, 16, 24, 32} {
+		peak := int64(1)<<(bitDepth-1) - 1
+		sampleBytes := bitDepth / 8
+
+		// Full-scale square wave and random noise, the worst cases for
+		// prediction.
+		rng := rand.New(rand.NewPCG(3, 4))
+		pcm := make([]byte, 3*blockSize*sampleBytes)
+		for i := range 3 * blockSize {
+			v := peak
+			switch {
+			case i < blockSize && i%2 == 1:
+				v = -peak - 1
+			case i >= blockSize:
+				v = rng.Int64N(2*peak+2) - peak - 1
+			}
+			PackSample(pcm[i*sampleBytes:], v, bitDepth)
+		}
+
+		encoded, err := Encode(pcm, bitDepth, 1, false)
+		if err != nil {
+			t.Fatalf("%d-bit: encode: %v", bitDepth, err)
+		}
+		decoded, _, err := Decode(encoded)
+		if err != nil {
+			t.Fatalf("%d-bit: decode: %v", bitDepth, err)
+		}
+		if !bytes.Equal(decoded, pcm) {
+			t.Errorf("%d-bit: decoded PCM differs from the input", bitDepth)
+		}
+	}
+}
+
+func TestEncodedSize(t *testing.T) {
+	cases := []struct {
+		bitDepth int
+		channels int
+		// Largest acceptable encoded size as a fraction of the PCM
+		maxRatio float64
+	}{
+		{16, 1, 0.6},
+		{16, 2, 0.6},
+		{24, 2, 0.7},
+	}
+
+	for _, c := range cases {
+		pcm := music(10*44100, c.bitDepth, c.channels, false)
+		encoded, err := Encode(pcm, c.bitDepth, c.channels, false)
+		if err != nil {
+			t.Fatalf("%d-bit, %d channels: encode: %v", c.bitDepth, c.channels, err)
+		}
+
+		ratio := float64(len(encoded)) / float64(len(pcm))
+		if ratio > c.maxRatio {
+			t.Errorf("%d-bit, %d channels: encoded to %.2f of the PCM size, expected at most %.2f",
+				c.bitDepth, c.channels, ratio, c.maxRatio)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	pcm := music(2*blockSize+1234, 24, 2, false)
+	pcm = append(pcm, 0x5a)
+	encoded, err := Encode(pcm, 24, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One byte at a time, so nothing relies on the source being buffered
+	r, err := NewReader(iotest.OneByteReader(bytes.NewReader(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Header{BitDepth: 24, Channels: 2, Length: len(pcm)}); r.Header() != want {
+		t.Errorf("header %+v, expected %+v", r.Header(), want)
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, pcm) {
+		t.Error("decoded PCM differs from the input")
+	}
+
+	for _, data := range [][]byte{encoded[:len(encoded)-1], append(encoded, 0)} {
+		r, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Errorf("%d of %d bytes: expected an error", len(data), len(encoded))
+		}
+	}
+}
+
+func TestDecodeRejectsBadLength(t *testing.T) {
+	pcm := music(blockSize, 16, 2, false)
+	encoded, err := Encode(pcm, 16, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := encoded[len(writeHeader(Header{BitDepth: 16, Channels: 2, Length: len(pcm)})):]
+
+	for _, length := range []uint64{math.MaxUint64, maxLength + 1, 1 << 30} {
+		data := writeHeader(Header{BitDepth: 16, Channels: 2})
+		data = data[:len(data)-1]
+		data = binary.AppendUvarint(data, length)
+		data = append(data, body...)
+
+		if _, _, err := Decode(data); err == nil {
+			t.Errorf("length %d: expected an error", length)
+		}
+	}
+}
+
+func TestDecodeRejectsTruncated(t *testing.T) {
+	pcm := music(blockSize+100, 24, 2, false)
+	encoded, err := Encode(pcm, 24, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{0, 2, len(Magic) + 3, len(encoded) / 2, len(encoded) - 1} {
+		_, _, err := Decode(encoded[:n])
+		if err == nil {
+			t.Errorf("%d of %d bytes: expected an error", n, len(encoded))
+		}
+	}
+	if _, _, err := Decode(pcm); !errors.Is(err, ErrNotEncoded) {
+		t.Errorf("raw PCM: got %v, expected ErrNotEncoded", err)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, bitDepth := range []int{8, 16, 24, 32} {
+		encoded, err := Encode(music(300, bitDepth, 2, false), bitDepth, 2, false)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(encoded)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pcm, hdr, err := Decode(data)
+		if err != nil {
+			return
+		}
+		if len(pcm) != hdr.Length {
+			t.Fatalf("decoded %d bytes, header says %d", len(pcm), hdr.Length)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package codec
+
+import (
+	"math"
+)
+
+const (
+	maxLPCOrder    = 12
+	lpcOrderBits   = 4
+	lpcPrecision   = 14
+	precisionBits  = 4
+	maxLPCShift    = 15
+	shiftBits      = 4
+	fixedOrderBits = 3
+)
+
+// Polynomial predictors, as used by FLAC's fixed subframes.
+var fixedCoefs = [][]int64{
+	{},
+	{1},
+	{2, -1},
+	{3, -3, 1},
+	{4, -6, 4, -1},
+}
+
+// predictor describes an integer linear predictor:
+//
+//	x[i] = residual[i] + (sum(coefs[j] * x[i-1-j]) >> shift)
+type predictor struct {
+	kind      int
+	coefs     []int64
+	precision uint
+	shift     uint
+}
+
+func (p predictor) order() int {
+	return len(p.coefs)
+}
+
+func (p predictor) residual(samples []int64, out []int64) []int64 {
+	order := p.order()
+	out = out[:0]
+	for i := order; i < len(samples); i++ {
+		var sum int64
+		for j, c := range p.coefs {
+			sum += c * samples[i-1-j]
+		}
+		out = append(out, samples[i]-sum>>p.shift)
+	}
+	return out
+}
+
+// restore rebuilds samples in place, given warm-up samples already in
+// samples[:order] and residuals for the rest.
+func (p predictor) restore(samples []int64, residual []int64) {
+	order := p.order()
+	for i := order; i < len(samples); i++ {
+		var sum int64
+		for j, c := range p.coefs {
+			sum += c * samples[i-1-j]
+		}
+		samples[i] = residual[i-order] + sum>>p.shift
+	}
+}
+
+// lpcCandidates derives quantized predictors of several orders from the
+// block's autocorrelation via Levinson-Durbin recursion.
+func lpcCandidates(samples []int64) []predictor {
+	n := len(samples)
+	maxOrder := min(maxLPCOrder, n-1)
+	if maxOrder < 1 {
+		return nil
+	}
+
+	windowed := make([]float64, n)
+	half := float64(n-1) / 2
+	for i, s := range samples {
+		// Welch window
+		d := (float64(i) - half) / (half + 1)
+		windowed[i] = float64(s) * (1 - d*d)
+	}
+
+	autoc := make([]float64, maxOrder+1)
+	for lag := range autoc {
+		var sum float64
+		for i := lag; i < n; i++ {
+			sum += windowed[i] * windowed[i-lag]
+		}
+		autoc[lag] = sum
+	}
+	if autoc[0] == 0 {
+		return nil
+	}
+
+	var candidates []predictor
+
+	lpc := make([]float64, maxOrder)
+	tmp := make([]float64, maxOrder)
+	err := autoc[0]
+	for order := 1; order <= maxOrder; order++ {
+		acc := autoc[order]
+		for j := 0; j < order-1; j++ {
+			acc -= lpc[j] * autoc[order-1-j]
+		}
+		k := acc / err
+
+		copy(tmp, lpc)
+		lpc[order-1] = k
+		for j := 0; j < order-1; j++ {
+			lpc[j] = tmp[j] - k*tmp[order-2-j]
+		}
+
+		err *= 1 - k*k
+		if err <= 0 {
+			break
+		}
+
+		switch order {
+		case 2, 4, 8, 12:
+			if p, ok := quantize(lpc[:order]); ok {
+				candidates = append(candidates, p)
+			}
+		}
+	}
+
+	return candidates
+}
+
+func quantize(lpc []float64) (predictor, bool) {
+	var cmax float64
+	for _, c := range lpc {
+		cmax = math.Max(cmax, math.Abs(c))
+	}
+	if cmax == 0 {
+		return predictor{}, false
+	}
+
+	_, exp := math.Frexp(cmax)
+	shift := lpcPrecision - 1 - exp
+	if shift < 0 {
+		return predictor{}, false
+	}
+	shift = min(shift, maxLPCShift)
+
+	limit := int64(1)<<(lpcPrecision-1) - 1
+	coefs := make([]int64, len(lpc))
+
+	// Carry the rounding error forward so the quantized filter tracks
+	// the real one more closely.
+	var carry float64
+	for i, c := range lpc {
+		v := c*float64(int64(1)<<shift) + carry
+		q := int64(math.Round(v))
+		q = max(min(q, limit), -limit-1)
+		carry = v - float64(q)
+		coefs[i] = q
+	}
+
+	return predictor{
+		kind:      subframeLPC,
+		coefs:     coefs,
+		precision: lpcPrecision,
+		shift:     uint(shift),
+	}, true
+}
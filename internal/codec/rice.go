@@ -0,0 +1,156 @@
+package codec
+
+import (
+	"math/bits"
+)
+
+const (
+	riceParamBits      = 6
+	maxRiceParam       = 1<<riceParamBits - 1
+	partitionOrderBits = 3
+	maxPartitionOrder  = 1<<partitionOrderBits - 1
+
+	// Quotients this large are written as an escaped raw value instead
+	// of in unary, which keeps outliers from blowing up a partition.
+	riceEscape     = 24
+	escapeSizeBits = 7
+)
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func unzigzag(u uint64) int64 {
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+func riceCost(u uint64, k uint) uint64 {
+	q := u >> k
+	if q >= riceEscape {
+		return riceEscape + escapeSizeBits + uint64(bits.Len64(u))
+	}
+	return q + 1 + uint64(k)
+}
+
+func writeRice(w *bitWriter, u uint64, k uint) {
+	q := u >> k
+	if q >= riceEscape {
+		w.writeBits(0, riceEscape)
+		size := uint(bits.Len64(u))
+		w.writeBits(uint64(size), escapeSizeBits)
+		w.writeBits(u, size)
+		return
+	}
+	w.writeUnary(q)
+	w.writeBits(u, k)
+}
+
+func readRice(r *bitReader, k uint) uint64 {
+	q, ok := r.readUnary(riceEscape)
+	if !ok {
+		size := uint(r.readBits(escapeSizeBits))
+		return r.readBits(size)
+	}
+	return q<<k | r.readBits(k)
+}
+
+// bestRiceParam picks the parameter with the lowest exact cost for a
+// partition, searching around the estimate derived from its mean.
+func bestRiceParam(values []uint64) (uint, uint64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+
+	var sum uint64
+	for _, u := range values {
+		sum += u
+	}
+
+	guess := 0
+	if mean := sum / uint64(len(values)); mean > 0 {
+		guess = bits.Len64(mean) - 1
+	}
+
+	bestK := uint(0)
+	bestCost := ^uint64(0)
+	for k := max(guess-1, 0); k <= min(guess+1, maxRiceParam); k++ {
+		var cost uint64
+		for _, u := range values {
+			cost += riceCost(u, uint(k))
+		}
+		if cost < bestCost {
+			bestK = uint(k)
+			bestCost = cost
+		}
+	}
+
+	return bestK, bestCost
+}
+
+// partition splits n residuals into 2^order nearly equal runs, with the
+// last run taking the remainder.
+func partition(n int, order uint) [][2]int {
+	count := 1 << order
+	size := n / count
+
+	parts := make([][2]int, count)
+	for i := range count {
+		parts[i] = [2]int{i * size, (i + 1) * size}
+	}
+	parts[count-1][1] = n
+
+	return parts
+}
+
+type riceLayout struct {
+	order  uint
+	params []uint
+	cost   uint64
+}
+
+func planResidual(values []uint64) riceLayout {
+	best := riceLayout{cost: ^uint64(0)}
+
+	for order := uint(0); order <= maxPartitionOrder; order++ {
+		if len(values)>>order < 16 && order > 0 {
+			break
+		}
+
+		layout := riceLayout{order: order, cost: partitionOrderBits}
+		for _, p := range partition(len(values), order) {
+			k, cost := bestRiceParam(values[p[0]:p[1]])
+			layout.params = append(layout.params, k)
+			layout.cost += riceParamBits + cost
+		}
+
+		if layout.cost < best.cost {
+			best = layout
+		}
+	}
+
+	return best
+}
+
+func writeResidual(w *bitWriter, values []uint64, layout riceLayout) {
+	w.writeBits(uint64(layout.order), partitionOrderBits)
+	for i, p := range partition(len(values), layout.order) {
+		k := layout.params[i]
+		w.writeBits(uint64(k), riceParamBits)
+		for _, u := range values[p[0]:p[1]] {
+			writeRice(w, u, k)
+		}
+	}
+}
+
+func readResidual(r *bitReader, residual []int64) {
+	order := uint(r.readBits(partitionOrderBits))
+	for _, p := range partition(len(residual), order) {
+		k := uint(r.readBits(riceParamBits))
+		for i := p[0]; i < p[1]; i++ {
+			residual[i] = unzigzag(readRice(r, k))
+			if r.err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/knadh/koanf/parsers/toml"
+)
+
+// Tokens are per user rather than per project, so they are kept in the
+// user's config directory, keyed by server URL:
+//
+//	[tokens]
+//	"http://localhost:6969" = "stw_..."
+const credentialsFile = "credentials.toml"
+
+// STEWDIO_TOKEN overrides any stored token, e.g. for CI.
+const tokenEnv = "STEWDIO_TOKEN"
+
+func CredentialsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "stewdio", credentialsFile), nil
+}
+
+func normalizeServer(server string) string {
+	return strings.TrimRight(server, "/")
+}
+
+func loadTokens() (map[string]interface{}, error) {
+	path, err := CredentialsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]interface{}{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	creds, err := toml.Parser().Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	tokens, _ := creds["tokens"].(map[string]interface{})
+	if tokens == nil {
+		tokens = map[string]interface{}{}
+	}
+	return tokens, nil
+}
+
+// SaveToken remembers the token to send to a server.
+func SaveToken(server string, token string) error {
+	tokens, err := loadTokens()
+	if err != nil {
+		return err
+	}
+	tokens[normalizeServer(server)] = token
+
+	data, err := toml.Parser().Marshal(map[string]interface{}{"tokens": tokens})
+	if err != nil {
+		return fmt.Errorf("error marshalling to TOML: %w", err)
+	}
+
+	path, err := CredentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("error creating config directory: %w", err)
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
+
+// LoadToken returns the token for a server, or "" if there is none.
+func LoadToken(server string) (string, error) {
+	if token := os.Getenv(tokenEnv); token != "" {
+		return token, nil
+	}
+
+	tokens, err := loadTokens()
+	if err != nil {
+		return "", err
+	}
+
+	token, _ := tokens[normalizeServer(server)].(string)
+	return token, nil
+}
+
+// Authorize adds the stored token for a server to a request.
+func Authorize(req *http.Request, server string) error {
+	token, err := LoadToken(server)
+	if err != nil {
+		return fmt.Errorf("failed to load credentials: %w", err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
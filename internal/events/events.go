@@ -0,0 +1,175 @@
+// Package events passes what happens to projects on the server to
+// anyone listening, such as clients following a project live.
+package events
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	PinCreated     = "pin"
+	ProjectCreated = "project.created"
+	ProjectDeleted = "project.deleted"
+	// Someone was given a role in a project, or had theirs changed or
+	// taken away
+	MembersChanged = "project.members"
+)
+
+type Event struct {
+	// Increases with every event. IDs start from the time the server
+	// started, so they keep increasing across restarts.
+	ID      uint64    `json:"id"`
+	Type    string    `json:"type"`
+	Project string    `json:"project"`
+	Version string    `json:"version,omitempty"`
+	Parent  string    `json:"parent,omitempty"`
+	User    string    `json:"user"`
+	Time    time.Time `json:"time"`
+}
+
+const (
+	// Events kept for subscribers that reconnect
+	historySize = 256
+	// Events a subscriber may fall behind by before it is dropped
+	bufferSize = 64
+)
+
+type Broker struct {
+	// Held by Publish and Subscribe throughout, so events reach each
+	// subscriber in order while filters run without mu.
+	publishMu sync.Mutex
+
+	mu      sync.Mutex
+	lastID  uint64
+	history []Event
+	subs    map[*subscriber]struct{}
+	closed  bool
+}
+
+type subscriber struct {
+	ch     chan Event
+	filter func(Event) bool
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		lastID: uint64(time.Now().UnixMilli()),
+		subs:   map[*subscriber]struct{}{},
+	}
+}
+
+// Subscribe returns a channel receiving every event filter accepts,
+// starting with any still remembered after lastID, which is 0 for none.
+// filter is called while the event is published, so it sees the server
+// as it was when the event happened, but not with the broker locked, so
+// it may take its time. The channel is closed if the subscriber falls
+// too far behind, and cancel must be called when done.
+func (b *Broker) Subscribe(lastID uint64, filter func(Event) bool) (<-chan Event, func()) {
+	b.publishMu.Lock()
+	defer b.publishMu.Unlock()
+
+	sub := &subscriber{
+		ch:     make(chan Event, bufferSize+historySize),
+		filter: filter,
+	}
+
+	b.mu.Lock()
+	closed := b.closed
+	history := slices.Clone(b.history)
+	b.mu.Unlock()
+
+	if closed {
+		close(sub.ch)
+		return sub.ch, func() {}
+	}
+
+	if lastID != 0 {
+		for _, e := range history {
+			if e.ID > lastID && filter(e) {
+				sub.ch <- e
+			}
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(sub.ch)
+		return sub.ch, func() {}
+	}
+	b.subs[sub] = struct{}{}
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(sub)
+	}
+	return sub.ch, cancel
+}
+
+// Close ends every subscription, for when the server shuts down.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		b.remove(sub)
+	}
+}
+
+func (b *Broker) remove(sub *subscriber) {
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Publish stamps an event with its ID and time and sends it out.
+func (b *Broker) Publish(e Event) {
+	b.publishMu.Lock()
+	defer b.publishMu.Unlock()
+
+	b.mu.Lock()
+	b.lastID++
+	e.ID = b.lastID
+	e.Time = time.Now()
+
+	b.history = append(b.history, e)
+	if len(b.history) > historySize {
+		b.history = b.history[len(b.history)-historySize:]
+	}
+
+	subs := make([]*subscriber, 0, len(b.subs))
+	for sub := range b.subs {
+		subs = append(subs, sub)
+	}
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		if sub.filter(e) {
+			b.send(sub, e)
+		}
+	}
+}
+
+// send passes an event to a subscriber, unless it has gone since.
+func (b *Broker) send(sub *subscriber, e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+
+	select {
+	case sub.ch <- e:
+	default:
+		// Better to drop a stalled client, which can reconnect and
+		// catch up, than to hold up the server.
+		b.remove(sub)
+	}
+}
@@ -0,0 +1,122 @@
+package events
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBrokerFilters(t *testing.T) {
+	b := NewBroker()
+
+	song, cancelSong := b.Subscribe(0, func(e Event) bool { return e.Project == "song" })
+	defer cancelSong()
+	all, cancelAll := b.Subscribe(0, func(e Event) bool { return true })
+	defer cancelAll()
+
+	b.Publish(Event{Type: PinCreated, Project: "song", Version: "0.1"})
+	b.Publish(Event{Type: PinCreated, Project: "other", Version: "0.1"})
+	b.Publish(Event{Type: ProjectDeleted, Project: "song"})
+
+	if got := drain(song); len(got) != 2 || got[0].Type != PinCreated || got[1].Type != ProjectDeleted {
+		t.Errorf("song subscriber got %+v", got)
+	}
+	got := drain(all)
+	if len(got) != 3 {
+		t.Fatalf("subscriber to everything got %+v", got)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].ID <= got[i-1].ID {
+			t.Errorf("IDs out of order: %d after %d", got[i].ID, got[i-1].ID)
+		}
+	}
+
+	// Reconnecting after the first event replays the rest.
+	replay, cancelReplay := b.Subscribe(got[0].ID, func(e Event) bool { return true })
+	defer cancelReplay()
+	if again := drain(replay); len(again) != 2 || again[0].ID != got[1].ID {
+		t.Errorf("replay after %d got %+v", got[0].ID, again)
+	}
+}
+
+// A filter that takes its time must not hold up subscribers leaving or
+// the broker closing.
+func TestBrokerSlowFilter(t *testing.T) {
+	b := NewBroker()
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	_, cancelSlow := b.Subscribe(0, func(e Event) bool {
+		close(entered)
+		<-release
+		return true
+	})
+	defer cancelSlow()
+	_, cancelOther := b.Subscribe(0, func(e Event) bool { return true })
+
+	published := make(chan struct{})
+	go func() {
+		b.Publish(Event{Type: PinCreated, Project: "song"})
+		close(published)
+	}()
+	<-entered
+
+	done := make(chan struct{})
+	go func() {
+		cancelOther()
+		b.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelling and closing waited for a filter")
+	}
+
+	close(release)
+	<-published
+}
+
+func TestBrokerOrderWithConcurrentPublishers(t *testing.T) {
+	b := NewBroker()
+	ch, cancel := b.Subscribe(0, func(e Event) bool { return true })
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range bufferSize / 4 {
+				b.Publish(Event{Type: PinCreated, Project: "song"})
+			}
+		}()
+	}
+	wg.Wait()
+
+	got := drain(ch)
+	if len(got) != bufferSize/4*4 {
+		t.Fatalf("got %d events, expected %d", len(got), bufferSize/4*4)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].ID <= got[i-1].ID {
+			t.Fatalf("event %d arrived after %d", got[i].ID, got[i-1].ID)
+		}
+	}
+}
+
+// drain returns the events waiting on ch.
+func drain(ch <-chan Event) []Event {
+	var events []Event
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
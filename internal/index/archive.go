@@ -0,0 +1,147 @@
+package index
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"stewdio/internal/refs"
+)
+
+// ErrInvalidArchive means a pin archive could not be read, or does not
+// fit what it was pushed as.
+var ErrInvalidArchive = errors.New("invalid pin archive")
+
+// ReadArchive reads what the index keeps about a pin from its archive.
+// Version and Created are left for the caller to fill in.
+func ReadArchive(archive io.Reader) (PinRecord, error) {
+	var pin PinRecord
+
+	hash := sha256.New()
+	counter := &countingReader{r: io.TeeReader(archive, hash)}
+
+	gz, err := gzip.NewReader(counter)
+	if err != nil {
+		return pin, fmt.Errorf("failed to read gzip: %w", err)
+	}
+	defer func() { _ = gz.Close() }()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return pin, fmt.Errorf("failed to read tar: %w", err)
+		}
+
+		switch {
+		case hdr.Name == "message":
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return pin, err
+			}
+			pin.Message = string(data)
+		case hdr.Name == "parent":
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return pin, err
+			}
+			pin.Parent = strings.TrimSpace(string(data))
+		case hdr.Name == "diffs.json":
+			var diffs []refs.Diff
+			if err := json.NewDecoder(tr).Decode(&diffs); err != nil {
+				return pin, fmt.Errorf("failed to read diffs: %w", err)
+			}
+			pin.Diffs = diffs
+		case strings.HasPrefix(hdr.Name, "files/") && hdr.Typeflag == tar.TypeReg:
+			pin.Files = append(pin.Files, FileRecord{
+				Path: strings.TrimPrefix(hdr.Name, "files/"),
+				Size: hdr.Size,
+			})
+		}
+	}
+
+	// The gzip reader stops at the end of the stream, which may be
+	// before the end of the archive.
+	if _, err := io.Copy(io.Discard, counter); err != nil {
+		return pin, err
+	}
+
+	pin.Size = counter.n
+	pin.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return pin, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// checkingReader passes an archive through while ReadArchive reads a
+// copy of it. In place of io.EOF it returns ErrInvalidArchive if the
+// archive could not be read or check rejects it, so the backend stores
+// nothing, the same way VerifyReader stops a corrupted pin.
+type checkingReader struct {
+	r     io.Reader
+	pw    *io.PipeWriter
+	check func(*PinRecord) error
+	done  chan struct{}
+	pin   PinRecord
+	err   error
+}
+
+func newCheckingReader(r io.Reader, check func(*PinRecord) error) *checkingReader {
+	pr, pw := io.Pipe()
+	c := &checkingReader{r: r, pw: pw, check: check, done: make(chan struct{})}
+
+	go func() {
+		defer close(c.done)
+		c.pin, c.err = ReadArchive(pr)
+		// Keep taking what is passed through after a failure.
+		_, _ = io.Copy(io.Discard, pr)
+	}()
+
+	return c
+}
+
+func (c *checkingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		_, _ = c.pw.Write(p[:n])
+	}
+
+	if err == io.EOF {
+		_ = c.pw.Close()
+		<-c.done
+
+		if c.err != nil {
+			return n, fmt.Errorf("%w: %v", ErrInvalidArchive, c.err)
+		}
+		if err := c.check(&c.pin); err != nil {
+			return n, err
+		}
+	}
+
+	return n, err
+}
+
+// close stops reading the copy, for when the archive was not read to
+// the end. It is safe to call after that too.
+func (c *checkingReader) close() {
+	_ = c.pw.CloseWithError(io.ErrUnexpectedEOF)
+	<-c.done
+}
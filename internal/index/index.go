@@ -0,0 +1,295 @@
+// Package index keeps an embedded, transactional index of what the
+// server stores, so listings and lookups need neither directory scans
+// nor opening archives. The stored archives stay the source of truth:
+// the index can always be rebuilt from them.
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"stewdio/internal/refs"
+	"stewdio/internal/store"
+)
+
+// Layout:
+//
+//	meta/built                  set once a rebuild has finished
+//	projects/{project}          ProjectRecord
+//	pins/{project}/{version}    PinRecord
+var (
+	metaBucket     = []byte("meta")
+	projectsBucket = []byte("projects")
+	pinsBucket     = []byte("pins")
+
+	builtKey = []byte("built")
+)
+
+type ProjectRecord struct {
+	Name         string    `json:"name"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+type FileRecord struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+type PinRecord struct {
+	Version string `json:"version"`
+	// Version this pin was made from, empty for the first pin
+	Parent  string `json:"parent"`
+	Message string `json:"message"`
+	// User who pushed the pin, if known. Archives do not record it, so
+	// it only survives rebuilds of an existing index.
+	Author string `json:"author"`
+	// Size and hex SHA-256 of the archive
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// When the pin was stored
+	Created time.Time `json:"created"`
+	// Audio files stored in this pin's archive
+	Files []FileRecord `json:"files"`
+	// Changes from the parent
+	Diffs []refs.Diff `json:"diffs"`
+}
+
+type Index struct {
+	db *bolt.DB
+}
+
+func Open(path string) (*Index, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{metaBucket, projectsBucket, pinsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &Index{db: db}, nil
+}
+
+func (idx *Index) Close() error {
+	return idx.db.Close()
+}
+
+// Built reports whether the index holds everything in storage, which is
+// not the case for a new index or one whose rebuild was interrupted.
+func (idx *Index) Built() bool {
+	built := false
+	_ = idx.db.View(func(tx *bolt.Tx) error {
+		built = tx.Bucket(metaBucket).Get(builtKey) != nil
+		return nil
+	})
+	return built
+}
+
+// Invalidate marks the index as out of step with storage, so it is
+// rebuilt the next time the server starts.
+func (idx *Index) Invalidate() error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Delete(builtKey)
+	})
+}
+
+func (idx *Index) PutProject(project ProjectRecord) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(projectsBucket), project.Name, project)
+	})
+}
+
+func (idx *Index) Project(name string) (ProjectRecord, error) {
+	var project ProjectRecord
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(projectsBucket), name, &project)
+	})
+	return project, err
+}
+
+func (idx *Index) Projects() ([]ProjectRecord, error) {
+	var projects []ProjectRecord
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(projectsBucket).ForEach(func(k, v []byte) error {
+			var project ProjectRecord
+			if err := json.Unmarshal(v, &project); err != nil {
+				return err
+			}
+			projects = append(projects, project)
+			return nil
+		})
+	})
+	return projects, err
+}
+
+// DeleteProject forgets a project and all of its pins.
+func (idx *Index) DeleteProject(name string) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(projectsBucket).Delete([]byte(name)); err != nil {
+			return err
+		}
+
+		err := tx.Bucket(pinsBucket).DeleteBucket([]byte(name))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+// PutPin records a pin, creating its project if needed and moving the
+// project's modification time up to the pin's.
+func (idx *Index) PutPin(project string, pin PinRecord) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		projects := tx.Bucket(projectsBucket)
+
+		var record ProjectRecord
+		if err := getJSON(projects, project, &record); errors.Is(err, store.ErrNotFound) {
+			record = ProjectRecord{Name: project}
+		} else if err != nil {
+			return err
+		}
+		if pin.Created.After(record.LastModified) {
+			record.LastModified = pin.Created
+		}
+		if err := putJSON(projects, project, record); err != nil {
+			return err
+		}
+
+		pins, err := tx.Bucket(pinsBucket).CreateBucketIfNotExists([]byte(project))
+		if err != nil {
+			return err
+		}
+		return putJSON(pins, pin.Version, pin)
+	})
+}
+
+func (idx *Index) Pin(project string, version string) (PinRecord, error) {
+	var pin PinRecord
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		pins := tx.Bucket(pinsBucket).Bucket([]byte(project))
+		if pins == nil {
+			return store.ErrNotFound
+		}
+		return getJSON(pins, version, &pin)
+	})
+	return pin, err
+}
+
+// SetAuthor records who pushed a pin.
+func (idx *Index) SetAuthor(project string, version string, author string) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		pins := tx.Bucket(pinsBucket).Bucket([]byte(project))
+		if pins == nil {
+			return store.ErrNotFound
+		}
+
+		var pin PinRecord
+		if err := getJSON(pins, version, &pin); err != nil {
+			return err
+		}
+		pin.Author = author
+		return putJSON(pins, version, pin)
+	})
+}
+
+// authors returns who pushed each pin, keyed by project and version.
+func (idx *Index) authors() (map[string]map[string]string, error) {
+	authors := map[string]map[string]string{}
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pinsBucket).ForEachBucket(func(project []byte) error {
+			byVersion := map[string]string{}
+			authors[string(project)] = byVersion
+
+			return tx.Bucket(pinsBucket).Bucket(project).ForEach(func(k, v []byte) error {
+				var pin PinRecord
+				if err := json.Unmarshal(v, &pin); err != nil {
+					return err
+				}
+				if pin.Author != "" {
+					byVersion[pin.Version] = pin.Author
+				}
+				return nil
+			})
+		})
+	})
+	return authors, err
+}
+
+// Pins returns every pin in a project, oldest version first.
+func (idx *Index) Pins(project string) ([]PinRecord, error) {
+	var pins []PinRecord
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(projectsBucket).Get([]byte(project)) == nil {
+			return store.ErrNotFound
+		}
+
+		bucket := tx.Bucket(pinsBucket).Bucket([]byte(project))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var pin PinRecord
+			if err := json.Unmarshal(v, &pin); err != nil {
+				return err
+			}
+			pins = append(pins, pin)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	SortPins(pins)
+	return pins, nil
+}
+
+// Head returns the latest pin in a project, or false if it has none.
+func (idx *Index) Head(project string) (PinRecord, bool, error) {
+	pins, err := idx.Pins(project)
+	if err != nil || len(pins) == 0 {
+		return PinRecord{}, false, err
+	}
+	return pins[len(pins)-1], true, nil
+}
+
+// SortPins orders pins by version. Keys in the index sort as bytes,
+// which would put 0.10 before 0.2.
+func SortPins(pins []PinRecord) {
+	sort.Slice(pins, func(i, j int) bool {
+		return refs.VersionLess(pins[i].Version, pins[j].Version)
+	})
+}
+
+func putJSON(b *bolt.Bucket, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), data)
+}
+
+func getJSON(b *bolt.Bucket, key string, v any) error {
+	data := b.Get([]byte(key))
+	if data == nil {
+		return store.ErrNotFound
+	}
+	return json.Unmarshal(data, v)
+}
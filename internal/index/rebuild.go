@@ -0,0 +1,89 @@
+package index
+
+import (
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"stewdio/internal/store"
+)
+
+// Rebuild replaces the index with what is in the backend, reading every
+// pin archive once. Pins whose archives cannot be read are skipped.
+func (idx *Index) Rebuild(backend store.Store) error {
+	// Authors are not in the archives, so keep what the old index knew.
+	authors, err := idx.authors()
+	if err != nil {
+		return err
+	}
+
+	// Start from nothing, so projects removed from the backend behind
+	// the server's back are dropped too.
+	err = idx.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(metaBucket).Delete(builtKey); err != nil {
+			return err
+		}
+		for _, name := range [][]byte{projectsBucket, pinsBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	projects, err := backend.ListProjects()
+	if err != nil {
+		return fmt.Errorf("failed to list projects: %w", err)
+	}
+
+	s := &Store{backend: backend, idx: idx}
+
+	for _, name := range projects {
+		info, err := backend.GetProject(name)
+		if err != nil {
+			return fmt.Errorf("failed to read project %s: %w", name, err)
+		}
+		if err := idx.PutProject(ProjectRecord{Name: name, LastModified: info.LastModified}); err != nil {
+			return err
+		}
+
+		versions, err := backend.ListPins(name)
+		if err != nil {
+			return fmt.Errorf("failed to list pins in %s: %w", name, err)
+		}
+
+		pins := make([]PinRecord, 0, len(versions))
+		for _, version := range versions {
+			pin, err := s.readPin(name, version)
+			if err != nil {
+				// One bad archive should not keep the server from
+				// starting. The pin is left out until it is fixed.
+				fmt.Printf("error indexing %s, skipping it: %v\n", name, err)
+				continue
+			}
+			pins = append(pins, pin)
+		}
+
+		SortPins(pins)
+		for i, pin := range pins {
+			if pin.Parent == "" && i > 0 {
+				pin.Parent = pins[i-1].Version
+			}
+			pin.Author = authors[name][pin.Version]
+			if err := idx.PutPin(name, pin); err != nil {
+				return err
+			}
+		}
+	}
+
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(builtKey, []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+}
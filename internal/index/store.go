@@ -0,0 +1,163 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"stewdio/internal/store"
+)
+
+// Store serves listings and lookups from the index and keeps it up to
+// date as projects and pins are written to the backend. Pin archives
+// themselves are still read from the backend.
+type Store struct {
+	backend store.Store
+	idx     *Index
+}
+
+func NewStore(backend store.Store, idx *Index) *Store {
+	return &Store{backend: backend, idx: idx}
+}
+
+func (s *Store) ListProjects() ([]string, error) {
+	projects, err := s.idx.Projects()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(projects))
+	for _, project := range projects {
+		names = append(names, project.Name)
+	}
+	return names, nil
+}
+
+func (s *Store) CreateProject(name string) error {
+	if _, err := s.idx.Project(name); err == nil {
+		return store.ErrExists
+	}
+
+	if err := s.backend.CreateProject(name); err != nil {
+		return err
+	}
+
+	return s.record(s.idx.PutProject(ProjectRecord{Name: name, LastModified: time.Now()}))
+}
+
+func (s *Store) GetProject(name string) (store.ProjectInfo, error) {
+	project, err := s.idx.Project(name)
+	if err != nil {
+		return store.ProjectInfo{}, err
+	}
+	return store.ProjectInfo{Name: project.Name, LastModified: project.LastModified}, nil
+}
+
+func (s *Store) DeleteProject(name string) error {
+	if err := s.backend.DeleteProject(name); err != nil {
+		return err
+	}
+	return s.record(s.idx.DeleteProject(name))
+}
+
+func (s *Store) ListPins(project string) ([]string, error) {
+	pins, err := s.idx.Pins(project)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]string, 0, len(pins))
+	for _, pin := range pins {
+		versions = append(versions, pin.Version)
+	}
+	return versions, nil
+}
+
+// PutPin stores the archive, checking that it can be read, and records
+// it in the index. Pins whose archives do not name their parent get the
+// previous head.
+func (s *Store) PutPin(project string, version string, archive io.Reader) error {
+	head, hasHead, err := s.idx.Head(project)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	return s.putPin(project, version, archive, func(pin *PinRecord) error {
+		if pin.Parent == "" && hasHead {
+			pin.Parent = head.Version
+		}
+		return nil
+	})
+}
+
+// PutPinFrom is PutPin for a pin pushed as a child of parent, which is
+// empty for a project's first pin and should have been checked against
+// the head already. An archive naming a different parent is rejected.
+func (s *Store) PutPinFrom(project string, version string, parent string, archive io.Reader) error {
+	return s.putPin(project, version, archive, func(pin *PinRecord) error {
+		if pin.Parent != "" && pin.Parent != parent {
+			return fmt.Errorf("%w: it was made from %s, not %s", ErrInvalidArchive, pin.Parent, parent)
+		}
+		pin.Parent = parent
+		return nil
+	})
+}
+
+// putPin stores the archive if it can be read and check, which may
+// fill in the record, accepts it.
+func (s *Store) putPin(project string, version string, archive io.Reader, check func(*PinRecord) error) error {
+	r := newCheckingReader(archive, check)
+	defer r.close()
+
+	// Backends read the archive to the end before storing it, so the
+	// record is complete once they have.
+	if err := s.backend.PutPin(project, version, r); err != nil {
+		return err
+	}
+
+	pin := r.pin
+	pin.Version = version
+
+	f, info, err := s.backend.OpenPin(project, version)
+	if err != nil {
+		return s.record(err)
+	}
+	_ = f.Close()
+	pin.Created = info.Created
+
+	return s.record(s.idx.PutPin(project, pin))
+}
+
+func (s *Store) OpenPin(project string, version string) (io.ReadSeekCloser, store.PinInfo, error) {
+	return s.backend.OpenPin(project, version)
+}
+
+func (s *Store) readPin(project string, version string) (PinRecord, error) {
+	r, info, err := s.backend.OpenPin(project, version)
+	if err != nil {
+		return PinRecord{}, err
+	}
+	defer func() { _ = r.Close() }()
+
+	pin, err := ReadArchive(r)
+	if err != nil {
+		return pin, fmt.Errorf("failed to index pin %s: %w", version, err)
+	}
+	pin.Version = version
+	pin.Created = info.Created
+	return pin, nil
+}
+
+// record handles a failure to update the index after the backend has
+// already changed. The change stands, so the index is marked for a
+// rebuild rather than the error being returned.
+func (s *Store) record(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	fmt.Printf("error updating index, it will be rebuilt on restart: %v\n", err)
+	_ = s.idx.Invalidate()
+	return nil
+}
@@ -0,0 +1,154 @@
+package index
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"stewdio/internal/store"
+	"stewdio/internal/testutil"
+)
+
+func newTestStore(t *testing.T) (*Store, store.Store, *Index) {
+	t.Helper()
+
+	idx, err := Open(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = idx.Close() })
+
+	backend := store.NewMemoryStore()
+	if err := idx.Rebuild(backend); err != nil {
+		t.Fatal(err)
+	}
+	return NewStore(backend, idx), backend, idx
+}
+
+func TestPutPinFrom(t *testing.T) {
+	s, backend, idx := newTestStore(t)
+
+	first := testutil.Archive(t, map[string]string{"message": "first", "files/kick.wav": "kick"})
+	if err := s.PutPinFrom("song", "0.1", "", bytes.NewReader(first)); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		version string
+		parent  string
+		archive []byte
+	}{
+		{"not gzip", "0.2", "0.1", []byte("not an archive")},
+		{"not tar", "0.2", "0.1", func() []byte {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			_, _ = gz.Write(bytes.Repeat([]byte("x"), 1000))
+			_ = gz.Close()
+			return buf.Bytes()
+		}()},
+		{"truncated", "0.2", "0.1", first[:len(first)/2]},
+		{"bad diffs", "0.2", "0.1", testutil.Archive(t, map[string]string{"message": "m", "diffs.json": "{"})},
+		{"other parent", "0.2", "0.1", testutil.Archive(t, map[string]string{"message": "m", "parent": "0.7"})},
+		{"parent for a first pin", "0.1", "", testutil.Archive(t, map[string]string{"message": "m", "parent": "0.1"})},
+	}
+
+	for _, c := range cases {
+		project := "song"
+		if c.parent == "" {
+			project = "fresh"
+		}
+
+		err := s.PutPinFrom(project, c.version, c.parent, bytes.NewReader(c.archive))
+		if !errors.Is(err, ErrInvalidArchive) {
+			t.Errorf("%s: got %v, expected ErrInvalidArchive", c.name, err)
+		}
+		if _, _, err := backend.OpenPin(project, c.version); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("%s: the rejected pin was stored: %v", c.name, err)
+		}
+		if _, err := idx.Pin(project, c.version); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("%s: the rejected pin was indexed: %v", c.name, err)
+		}
+	}
+	if !idx.Built() {
+		t.Error("rejecting an archive invalidated the index")
+	}
+
+	// A checksum failure still comes through as one.
+	r, err := store.VerifyReader(bytes.NewReader(first), hex.EncodeToString(make([]byte, sha256.Size)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutPinFrom("song", "0.2", "0.1", r); !errors.Is(err, store.ErrChecksumMismatch) {
+		t.Errorf("corrupted upload: got %v, expected ErrChecksumMismatch", err)
+	}
+
+	// The parent recorded is the one pushed, whether or not the archive
+	// names it.
+	for _, archive := range [][]byte{
+		testutil.Archive(t, map[string]string{"message": "second"}),
+		testutil.Archive(t, map[string]string{"message": "second", "parent": "0.1"}),
+	} {
+		if err := s.PutPinFrom("song", "0.2", "0.1", bytes.NewReader(archive)); err != nil {
+			t.Fatal(err)
+		}
+		pin, err := idx.Pin("song", "0.2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pin.Parent != "0.1" || pin.Message != "second" || pin.Created.IsZero() {
+			t.Errorf("got record %+v, expected parent 0.1", pin)
+		}
+		if err := s.DeleteProject("song"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.PutPinFrom("song", "0.1", "", bytes.NewReader(first)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pin, err := idx.Pin("song", "0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(first)
+	if pin.SHA256 != hex.EncodeToString(sum[:]) || pin.Size != int64(len(first)) ||
+		len(pin.Files) != 1 || pin.Files[0].Path != "kick.wav" {
+		t.Errorf("got record %+v for the first pin", pin)
+	}
+}
+
+func TestRebuildSkipsUnreadablePins(t *testing.T) {
+	_, backend, idx := newTestStore(t)
+
+	good := testutil.Archive(t, map[string]string{"message": "good"})
+	for version, archive := range map[string][]byte{"0.1": good, "0.2": []byte("garbage"), "0.3": good} {
+		if err := backend.PutPin("song", version, bytes.NewReader(archive)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := idx.Rebuild(backend); err != nil {
+		t.Fatalf("rebuild failed: %v", err)
+	}
+	if !idx.Built() {
+		t.Error("index is not marked built")
+	}
+
+	pins, err := idx.Pins("song")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []string
+	for _, pin := range pins {
+		versions = append(versions, pin.Version)
+	}
+	if !slices.Equal(versions, []string{"0.1", "0.3"}) {
+		t.Errorf("got pins %v, expected [0.1 0.3]", versions)
+	}
+}
@@ -0,0 +1,65 @@
+package peaks
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"stewdio/internal/utils"
+)
+
+// Cache keeps computed peaks on disk. Only zoom level 0 is stored, as
+// the other levels are quick to derive from it.
+type Cache struct {
+	dir string
+}
+
+func NewCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Get returns the peaks stored under key, computing and storing them
+// first if needed. Keys must change whenever the audio does.
+func (c *Cache) Get(key string, compute func() (*Peaks, error)) (*Peaks, error) {
+	sum := sha256.Sum256([]byte(key))
+	path := filepath.Join(c.dir, hex.EncodeToString(sum[:])+".dat")
+
+	if f, err := os.Open(path); err == nil {
+		p, err := ReadBinary(bufio.NewReader(f))
+		_ = f.Close()
+		if err == nil {
+			return p, nil
+		}
+		// Computed again below.
+		fmt.Printf("error reading cached peaks %s: %v\n", path, err)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	p, err := compute()
+	if err != nil {
+		return nil, err
+	}
+
+	err = utils.WriteFileAtomic(path, 0o644, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		if err := p.WriteBinary(bw); err != nil {
+			return err
+		}
+		return bw.Flush()
+	})
+	if err != nil {
+		fmt.Printf("error caching peaks: %v\n", err)
+	}
+
+	return p, nil
+}
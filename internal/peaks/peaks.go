@@ -0,0 +1,213 @@
+// Package peaks computes the min/max peak data used to draw waveforms
+// without decoding the audio. Peaks are stored and served in the binary
+// and JSON formats of audiowaveform, which waveform-data.js reads.
+package peaks
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"stewdio/internal/wavfile"
+)
+
+const (
+	// Frames per peak at zoom level 0. Each level up halves the detail.
+	BaseSamplesPerPixel = 256
+	MaxZoom             = 12
+
+	formatVersion = 2
+	// Flag set in the binary header when values are 8-bit
+	flag8Bit = 1
+)
+
+// Peaks holds, for each point and channel in turn, the minimum and
+// maximum sample as 16-bit values.
+type Peaks struct {
+	SampleRate      int
+	SamplesPerPixel int
+	Channels        int
+	Data            []int16
+}
+
+// Length is the number of points.
+func (p *Peaks) Length() int {
+	if p.Channels == 0 {
+		return 0
+	}
+	return len(p.Data) / (2 * p.Channels)
+}
+
+// Compute reads a WAV file and returns its peaks at zoom level 0.
+func Compute(r io.ReaderAt, size int64) (*Peaks, error) {
+	wav, err := wavfile.Parse(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	format := wav.Format
+	decode, err := format.SampleDecoder()
+	if err != nil {
+		return nil, err
+	}
+
+	channels := int(format.Channels)
+	if channels == 0 {
+		return nil, fmt.Errorf("%w: no channels", wavfile.ErrUnsupportedFormat)
+	}
+	sampleBytes := int(format.BitsPerSample) / 8
+	if sampleBytes*channels > int(format.BlockAlign) {
+		return nil, fmt.Errorf("%w: block alignment too small", wavfile.ErrUnsupportedFormat)
+	}
+
+	p := &Peaks{
+		SampleRate:      int(format.SampleRate),
+		SamplesPerPixel: BaseSamplesPerPixel,
+		Channels:        channels,
+	}
+
+	data := wav.Data()
+	br := bufio.NewReaderSize(io.NewSectionReader(r, data.DataOffset(), data.Size), 64<<10)
+
+	frame := make([]byte, format.BlockAlign)
+	mins := make([]int16, channels)
+	maxs := make([]int16, channels)
+	inPoint := 0
+
+	flush := func() {
+		for c := range channels {
+			p.Data = append(p.Data, mins[c], maxs[c])
+			mins[c], maxs[c] = math.MaxInt16, math.MinInt16
+		}
+		inPoint = 0
+	}
+	for c := range channels {
+		mins[c], maxs[c] = math.MaxInt16, math.MinInt16
+	}
+
+	for {
+		if _, err := io.ReadFull(br, frame); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, err
+		}
+
+		for c := range channels {
+			v := int16(decode(frame[c*sampleBytes:(c+1)*sampleBytes]) * math.MaxInt16)
+			mins[c] = min(mins[c], v)
+			maxs[c] = max(maxs[c], v)
+		}
+
+		inPoint++
+		if inPoint == BaseSamplesPerPixel {
+			flush()
+		}
+	}
+	if inPoint > 0 {
+		flush()
+	}
+
+	return p, nil
+}
+
+// Zoom merges every 2^level points into one.
+func (p *Peaks) Zoom(level int) *Peaks {
+	if level <= 0 {
+		return p
+	}
+
+	factor := 1 << level
+	stride := 2 * p.Channels
+	length := p.Length()
+
+	z := &Peaks{
+		SampleRate:      p.SampleRate,
+		SamplesPerPixel: p.SamplesPerPixel * factor,
+		Channels:        p.Channels,
+		Data:            make([]int16, 0, (length+factor-1)/factor*stride),
+	}
+
+	for start := 0; start < length; start += factor {
+		end := min(start+factor, length)
+		for c := range p.Channels {
+			lo, hi := int16(math.MaxInt16), int16(math.MinInt16)
+			for i := start; i < end; i++ {
+				lo = min(lo, p.Data[i*stride+2*c])
+				hi = max(hi, p.Data[i*stride+2*c+1])
+			}
+			z.Data = append(z.Data, lo, hi)
+		}
+	}
+
+	return z
+}
+
+// WriteBinary writes peaks in the audiowaveform binary format, version 2.
+func (p *Peaks) WriteBinary(w io.Writer) error {
+	header := []int32{
+		formatVersion,
+		0, // 16-bit values
+		int32(p.SampleRate),
+		int32(p.SamplesPerPixel),
+		int32(p.Length()),
+		int32(p.Channels),
+	}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, p.Data)
+}
+
+func ReadBinary(r io.Reader) (*Peaks, error) {
+	var header [6]int32
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to read peaks header: %w", err)
+	}
+	if header[0] != formatVersion {
+		return nil, fmt.Errorf("unsupported peaks version %d", header[0])
+	}
+	if header[1]&flag8Bit != 0 {
+		return nil, fmt.Errorf("8-bit peaks are not supported")
+	}
+	if header[4] < 0 || header[5] <= 0 {
+		return nil, fmt.Errorf("invalid peaks header")
+	}
+
+	p := &Peaks{
+		SampleRate:      int(header[2]),
+		SamplesPerPixel: int(header[3]),
+		Channels:        int(header[5]),
+		Data:            make([]int16, int(header[4])*2*int(header[5])),
+	}
+	if err := binary.Read(r, binary.LittleEndian, p.Data); err != nil {
+		return nil, fmt.Errorf("failed to read peaks: %w", err)
+	}
+	return p, nil
+}
+
+type peaksJSON struct {
+	Version         int     `json:"version"`
+	Channels        int     `json:"channels"`
+	SampleRate      int     `json:"sample_rate"`
+	SamplesPerPixel int     `json:"samples_per_pixel"`
+	Bits            int     `json:"bits"`
+	Length          int     `json:"length"`
+	Data            []int16 `json:"data"`
+}
+
+// MarshalJSON uses the audiowaveform JSON format.
+func (p *Peaks) MarshalJSON() ([]byte, error) {
+	return json.Marshal(peaksJSON{
+		Version:         formatVersion,
+		Channels:        p.Channels,
+		SampleRate:      p.SampleRate,
+		SamplesPerPixel: p.SamplesPerPixel,
+		Bits:            16,
+		Length:          p.Length(),
+		Data:            p.Data,
+	})
+}
@@ -2,72 +2,403 @@ package pin_utils
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"stewdio/internal/config"
 	"stewdio/internal/refs"
+	"stewdio/internal/utils"
 )
 
-func Push(path string, remote config.Remote, version string) error {
-	var buf bytes.Buffer
-	writer := multipart.NewWriter(&buf)
+const (
+	// Consecutive failed requests tolerated before giving up
+	maxRetries = 5
+	retryDelay = time.Second
+)
 
-	metadataBytes, err := json.Marshal(map[string]string{
-		"version": version,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to encode metadata: %w", err)
-	}
+// NotFastForwardError means the remote has pins the local history does
+// not, so the pin was rejected.
+type NotFastForwardError struct {
+	// The remote's latest version
+	Latest string
+}
 
-	if err := writer.WriteField("meta", string(metadataBytes)); err != nil {
-		return fmt.Errorf("failed to write meta field: %w", err)
-	}
+func (e *NotFastForwardError) Error() string {
+	return fmt.Sprintf("the remote has moved on to version %s", e.Latest)
+}
 
+// Push uploads a pin made from parent, which is empty for a project's
+// first pin, through a resumable upload session. The session is
+// remembered in .stew/uploads, so a Push that was interrupted, even by
+// the process exiting, carries on from what the server already has.
+func Push(path string, remote config.Remote, version string, parent string) error {
 	filePath := filepath.Join(path, ".stew", "objects", version, refs.ObjectTarName)
 
+	sum, err := utils.HashFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to hash file: %w", err)
+	}
+
 	file, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
 	defer func() { _ = file.Close() }()
 
-	part, err := writer.CreateFormFile("file", filepath.Base(filePath))
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	projectURL := fmt.Sprintf("%s/api/v1/projects/%s", strings.TrimRight(remote.Server, "/"), remote.Project)
+	c := &uploadClient{
+		remote:  remote,
+		project: projectURL,
+		base:    projectURL + "/uploads",
+	}
+
+	statePath := filepath.Join(path, ".stew", "uploads", version+".json")
+	state := uploadState{
+		Server:  remote.Server,
+		Project: remote.Project,
+		SHA256:  hex.EncodeToString(sum),
+	}
+
+	status, err := c.resume(statePath, state)
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		status, err = c.create(version, parent, state.SHA256, info.Size())
+		if err != nil {
+			return err
+		}
+
+		state.ID = status.ID
+		if err := saveUploadState(statePath, state); err != nil {
+			return err
+		}
+	} else if status.Offset > 0 {
+		fmt.Printf("Resuming upload of %s at %d of %d bytes\n", version, status.Offset, status.Size)
+	}
+
+	for {
+		for status.Offset < status.Size {
+			status, err = c.sendChunk(status, file)
+			if err != nil {
+				return fmt.Errorf("upload interrupted, run the command again to resume: %w", err)
+			}
+		}
+
+		done, next, err := c.finalize(status, version, state.SHA256)
+		if err != nil {
+			var final *finalError
+			var conflict *NotFastForwardError
+			if errors.As(err, &final) || errors.As(err, &conflict) {
+				_ = os.Remove(statePath)
+			}
+			return err
+		}
+		if done {
+			break
+		}
+		status = next
+	}
+
+	_ = os.Remove(statePath)
+	return nil
+}
+
+// uploadState is what a later Push needs to resume an upload.
+type uploadState struct {
+	Server  string `json:"server"`
+	Project string `json:"project"`
+	ID      string `json:"id"`
+	SHA256  string `json:"sha256"`
+}
+
+func saveUploadState(path string, state uploadState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	stateBytes, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, stateBytes, 0o644)
+}
+
+type uploadStatus struct {
+	ID        string `json:"id"`
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunkSize"`
+}
+
+// finalError is an upload failure that retrying cannot fix.
+type finalError struct {
+	msg string
+}
+
+func (e *finalError) Error() string {
+	return e.msg
+}
+
+type uploadClient struct {
+	remote  config.Remote
+	project string
+	base    string
+}
+
+// do sends a request, retrying on network errors and server errors.
+// body is called for every attempt.
+func (c *uploadClient) do(method string, url string, body func() (io.Reader, int64, error)) (*http.Response, error) {
+	var lastErr error
+
+	for attempt := range maxRetries {
+		if attempt > 0 {
+			time.Sleep(retryDelay << (attempt - 1))
+		}
+
+		var reader io.Reader
+		length := int64(0)
+		if body != nil {
+			var err error
+			reader, length, err = body()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		req, err := http.NewRequest(method, url, reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		if reader != nil {
+			req.ContentLength = length
+		}
+		if err := config.Authorize(req, c.remote.Server); err != nil {
+			return nil, err
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("request failed: %w", err)
+			continue
+		}
+
+		if res.StatusCode >= 500 {
+			msg, _ := io.ReadAll(res.Body)
+			_ = res.Body.Close()
+			lastErr = fmt.Errorf("server error: %s\n%s", res.Status, string(msg))
+			continue
+		}
+
+		if res.StatusCode == http.StatusUnauthorized {
+			_ = res.Body.Close()
+			return nil, fmt.Errorf("not logged in to %s, run \"stewdio login\"", c.remote.Server)
+		}
+
+		return res, nil
+	}
+
+	return nil, lastErr
+}
+
+func decodeStatus(res *http.Response) (*uploadStatus, error) {
+	var status uploadStatus
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return &status, nil
+}
+
+// parseConflict reads the body of a 409 from the server, and returns it
+// as a NotFastForwardError if it is one.
+func parseConflict(res *http.Response) ([]byte, *NotFastForwardError) {
+	body, _ := io.ReadAll(res.Body)
+
+	var conflict struct {
+		Error  string `json:"error"`
+		Latest string `json:"latest"`
+	}
+	if err := json.Unmarshal(body, &conflict); err == nil && conflict.Error == "not_fast_forward" {
+		return body, &NotFastForwardError{Latest: conflict.Latest}
+	}
+	return body, nil
+}
+
+func responseError(prefix string, res *http.Response) error {
+	msg, _ := io.ReadAll(res.Body)
+	return fmt.Errorf("%s: %s\n%s", prefix, res.Status, string(msg))
+}
+
+// resume returns the status of the session saved at statePath, or nil
+// if there is none that can be continued.
+func (c *uploadClient) resume(statePath string, want uploadState) (*uploadStatus, error) {
+	stateBytes, err := os.ReadFile(statePath)
 	if err != nil {
-		return fmt.Errorf("failed to create form file: %w", err)
+		return nil, nil
 	}
 
-	if _, err := io.Copy(part, file); err != nil {
-		return fmt.Errorf("failed to copy file data: %w", err)
+	var state uploadState
+	if err := json.Unmarshal(stateBytes, &state); err != nil ||
+		state.Server != want.Server || state.Project != want.Project || state.SHA256 != want.SHA256 {
+		// The pin changed since, or was pushed elsewhere.
+		return nil, nil
 	}
 
-	if err := writer.Close(); err != nil {
-		return fmt.Errorf("failed to close multipart writer: %w", err)
+	res, err := c.do("GET", c.base+"/"+state.ID, nil)
+	if err != nil {
+		return nil, err
 	}
+	defer func() { _ = res.Body.Close() }()
 
-	url := fmt.Sprintf("%s/api/v1/projects/%s/pins", remote.Server, remote.Project)
+	if res.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+	return decodeStatus(res)
+}
 
-	req, err := http.NewRequest("POST", url, &buf)
+func (c *uploadClient) create(version string, parent string, sha256 string, size int64) (*uploadStatus, error) {
+	reqBytes, err := json.Marshal(map[string]any{
+		"version": version,
+		"parent":  parent,
+		"sha256":  sha256,
+		"size":    size,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to encode request: %w", err)
 	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := c.do("POST", c.base, func() (io.Reader, int64, error) {
+		return bytes.NewReader(reqBytes), int64(len(reqBytes)), nil
+	})
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return nil, err
 	}
 	defer func() { _ = res.Body.Close() }()
 
+	if res.StatusCode == http.StatusConflict {
+		if _, conflict := parseConflict(res); conflict != nil {
+			return nil, conflict
+		}
+		return nil, fmt.Errorf("upload failed: %s", res.Status)
+	}
 	if res.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("upload failed: %s\n%s", res.Status, string(body))
+		return nil, responseError("upload failed", res)
 	}
+	return decodeStatus(res)
+}
 
-	return nil
+// sendChunk uploads the chunk at the received offset and returns the
+// new status.
+func (c *uploadClient) sendChunk(status *uploadStatus, file io.ReaderAt) (*uploadStatus, error) {
+	n := status.Offset / status.ChunkSize
+	start := n * status.ChunkSize
+	length := min(status.ChunkSize, status.Size-start)
+
+	url := fmt.Sprintf("%s/%s/chunks/%d", c.base, status.ID, n)
+	res, err := c.do("PUT", url, func() (io.Reader, int64, error) {
+		return io.NewSectionReader(file, start, length), length, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusConflict:
+		// A conflict means the server is elsewhere; it says where.
+		next, err := decodeStatus(res)
+		if err != nil {
+			return nil, err
+		}
+		if next.Offset == status.Offset && res.StatusCode == http.StatusConflict {
+			return nil, fmt.Errorf("server rejected chunk %d", n)
+		}
+		return next, nil
+	default:
+		return nil, responseError("upload failed", res)
+	}
+}
+
+// finalize asks the server to store the pin. If the server turns out to
+// be missing data, it returns the status to carry on from instead.
+func (c *uploadClient) finalize(status *uploadStatus, version string, sha256 string) (bool, *uploadStatus, error) {
+	res, err := c.do("POST", c.base+"/"+status.ID+"/finalize", nil)
+	if err != nil {
+		return false, nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	switch res.StatusCode {
+	case http.StatusCreated:
+		return true, nil, nil
+	case http.StatusConflict:
+		body, conflict := parseConflict(res)
+		if conflict != nil {
+			return false, nil, conflict
+		}
+
+		var next uploadStatus
+		if err := json.Unmarshal(body, &next); err == nil && next.ID != "" {
+			return false, &next, nil
+		}
+		return false, nil, &finalError{msg: "upload failed: " + strings.TrimSpace(string(body))}
+	case http.StatusUnprocessableEntity:
+		msg, _ := io.ReadAll(res.Body)
+		return false, nil, &finalError{msg: fmt.Sprintf("upload failed: %s", strings.TrimSpace(string(msg)))}
+	case http.StatusNotFound:
+		// The session is removed once the pin is stored, so a retry
+		// after a lost response lands here. Whether the pin made it
+		// decides if there is anything left to do.
+		stored, err := c.pinStored(version, sha256)
+		if err != nil {
+			return false, nil, err
+		}
+		if stored {
+			return true, nil, nil
+		}
+		return false, nil, &finalError{msg: "upload failed: the upload session expired, run the command again to start over"}
+	default:
+		return false, nil, responseError("upload failed", res)
+	}
+}
+
+// pinStored reports whether the server has version with the given
+// SHA-256. A different pin stored under the version is an error.
+func (c *uploadClient) pinStored(version string, sha256 string) (bool, error) {
+	res, err := c.do("GET", c.project+"/pins/"+version+"/meta", nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		var meta struct {
+			SHA256 string `json:"sha256"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&meta); err != nil {
+			return false, fmt.Errorf("invalid response: %w", err)
+		}
+		if meta.SHA256 != sha256 {
+			return false, &finalError{msg: fmt.Sprintf("upload failed: the server has a different pin for version %s", version)}
+		}
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, responseError("failed to check for pin", res)
+	}
 }
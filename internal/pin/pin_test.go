@@ -0,0 +1,123 @@
+package pin_utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"stewdio/internal/config"
+	"stewdio/internal/refs"
+)
+
+// fakeUploads plays the server's side of an upload session, storing the
+// pin on finalize but answering with a 502, as if the response was lost
+// on the way back. The session is gone after that, like on the server.
+type fakeUploads struct {
+	mu       sync.Mutex
+	data     []byte
+	size     int64
+	finished bool
+	// SHA-256 of the pin the server has, if any
+	stored string
+	// What the pin's meta says, if set, instead of stored
+	storedOverride string
+	// Whether finalize forgets the pin instead of storing it
+	dropPin bool
+}
+
+func (f *fakeUploads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status := func() {
+		_ = json.NewEncoder(w).Encode(uploadStatus{ID: "u1", Offset: int64(len(f.data)), Size: f.size, ChunkSize: 1 << 20})
+	}
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/api/v1/projects/song/uploads":
+		var req struct {
+			Size int64 `json:"size"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		f.size = req.Size
+		w.WriteHeader(http.StatusCreated)
+		status()
+	case r.Method == "PUT" && r.URL.Path == "/api/v1/projects/song/uploads/u1/chunks/0":
+		f.data, _ = io.ReadAll(r.Body)
+		status()
+	case r.Method == "POST" && r.URL.Path == "/api/v1/projects/song/uploads/u1/finalize":
+		if f.finished {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+		f.finished = true
+		if !f.dropPin {
+			sum := sha256.Sum256(f.data)
+			f.stored = hex.EncodeToString(sum[:])
+		}
+		http.Error(w, "Bad gateway", http.StatusBadGateway)
+	case r.Method == "GET" && r.URL.Path == "/api/v1/projects/song/pins/0.1/meta":
+		sha := f.stored
+		if f.storedOverride != "" {
+			sha = f.storedOverride
+		}
+		if sha == "" {
+			http.Error(w, "Version not found", http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"version": "0.1", "sha256": sha})
+	default:
+		http.Error(w, "unexpected request", http.StatusTeapot)
+	}
+}
+
+func TestPushLostFinalizeResponse(t *testing.T) {
+	t.Setenv("STEWDIO_TOKEN", "stw_test")
+
+	cases := []struct {
+		name   string
+		server *fakeUploads
+		ok     bool
+	}{
+		{"pin was stored", &fakeUploads{}, true},
+		{"pin was not stored", &fakeUploads{dropPin: true}, false},
+		{"another pin was stored", &fakeUploads{storedOverride: strings.Repeat("0", 64)}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(c.server)
+			defer server.Close()
+
+			dir := t.TempDir()
+			objectDir := filepath.Join(dir, ".stew", "objects", "0.1")
+			if err := os.MkdirAll(objectDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(objectDir, refs.ObjectTarName), []byte("not really an archive"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := Push(dir, config.Remote{Server: server.URL, Project: "song"}, "0.1", "")
+			if c.ok && err != nil {
+				t.Fatalf("push failed: %v", err)
+			}
+			if !c.ok && err == nil {
+				t.Fatal("push succeeded, expected an error")
+			}
+
+			// Either way, there is no session left to resume.
+			if _, err := os.Stat(filepath.Join(dir, ".stew", "uploads", "0.1.json")); !os.IsNotExist(err) {
+				t.Errorf("upload state was kept: %v", err)
+			}
+		})
+	}
+}
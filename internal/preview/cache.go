@@ -0,0 +1,46 @@
+package preview
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"stewdio/internal/utils"
+)
+
+// Cache keeps rendered previews on disk.
+type Cache struct {
+	dir string
+}
+
+func NewCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Open returns the preview stored under key, rendering and storing it
+// first if needed. Keys must change whenever the audio or the options
+// do.
+func (c *Cache) Open(key string, render func(w io.Writer) error) (*os.File, error) {
+	sum := sha256.Sum256([]byte(key))
+	path := filepath.Join(c.dir, hex.EncodeToString(sum[:])+".wav")
+
+	f, err := os.Open(path)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if err := utils.WriteFileAtomic(path, 0o644, render); err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
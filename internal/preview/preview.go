@@ -0,0 +1,228 @@
+// Package preview renders lightweight 16-bit WAV previews of tracked
+// files, for listening without fetching full resolution masters.
+package preview
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+
+	"stewdio/internal/wavfile"
+)
+
+// Sample rates a preview can be rendered at.
+var SampleRates = []int{8000, 11025, 16000, 22050, 24000, 32000, 44100, 48000}
+
+const DefaultSampleRate = 44100
+
+type Options struct {
+	// Target sample rate, lowered to the source's if that is lower, as
+	// previews never upsample
+	SampleRate int
+	// 1 mixes every channel down to mono; 2 keeps the first two
+	// channels, doubling a mono source. 0 keeps mono as mono and
+	// everything else as stereo.
+	Channels int
+}
+
+func (o Options) Validate() error {
+	if !slices.Contains(SampleRates, o.SampleRate) {
+		return fmt.Errorf("unsupported sample rate %d", o.SampleRate)
+	}
+	if o.Channels < 0 || o.Channels > 2 {
+		return fmt.Errorf("channels must be 1 or 2")
+	}
+	return nil
+}
+
+// Render writes a preview of the WAV file in r to w.
+func Render(w io.Writer, r io.ReaderAt, size int64, opts Options) error {
+	wav, err := wavfile.Parse(r, size)
+	if err != nil {
+		return err
+	}
+
+	in := wav.Format
+	decode, err := in.SampleDecoder()
+	if err != nil {
+		return err
+	}
+
+	inChannels := int(in.Channels)
+	sampleBytes := int(in.BitsPerSample) / 8
+	if inChannels == 0 || in.SampleRate == 0 || sampleBytes*inChannels > int(in.BlockAlign) {
+		return fmt.Errorf("%w: invalid fmt chunk", wavfile.ErrUnsupportedFormat)
+	}
+
+	outRate := min(opts.SampleRate, int(in.SampleRate))
+	outChannels := opts.Channels
+	if outChannels == 0 {
+		outChannels = min(inChannels, 2)
+	}
+
+	data := wav.Data()
+	inFrames := data.Size / int64(in.BlockAlign)
+	outFrames := inFrames * int64(outRate) / int64(in.SampleRate)
+
+	out := wavfile.Format{
+		AudioFormat:   wavfile.FormatPCM,
+		Channels:      uint16(outChannels),
+		SampleRate:    uint32(outRate),
+		ByteRate:      uint32(outRate * outChannels * 2),
+		BlockAlign:    uint16(outChannels * 2),
+		BitsPerSample: 16,
+	}
+	dataSize := outFrames * int64(out.BlockAlign)
+
+	bw := bufio.NewWriterSize(w, 64<<10)
+	if _, err := bw.Write(wavfile.Header(out, dataSize)); err != nil {
+		return err
+	}
+
+	br := bufio.NewReaderSize(io.NewSectionReader(r, data.DataOffset(), data.Size), 64<<10)
+	frame := make([]byte, in.BlockAlign)
+
+	// readFrame decodes the next source frame into outChannels values.
+	readFrame := func(dst []float64) error {
+		if _, err := io.ReadFull(br, frame); err != nil {
+			return err
+		}
+
+		sample := func(c int) float64 {
+			return decode(frame[c*sampleBytes : (c+1)*sampleBytes])
+		}
+
+		switch {
+		case outChannels == 1:
+			sum := 0.0
+			for c := range inChannels {
+				sum += sample(c)
+			}
+			dst[0] = sum / float64(inChannels)
+		case inChannels == 1:
+			dst[0] = sample(0)
+			dst[1] = dst[0]
+		default:
+			dst[0] = sample(0)
+			dst[1] = sample(1)
+		}
+		return nil
+	}
+
+	rs := newResampler(outChannels, float64(in.SampleRate)/float64(outRate), inFrames, readFrame)
+
+	samples := make([]byte, out.BlockAlign)
+	values := make([]float64, outChannels)
+	for k := range outFrames {
+		if err := rs.frame(k, values); err != nil {
+			return fmt.Errorf("failed to read samples: %w", err)
+		}
+
+		for c, v := range values {
+			s := int16(math.Round(max(-1, min(1, v)) * math.MaxInt16))
+			binary.LittleEndian.PutUint16(samples[2*c:], uint16(s))
+		}
+		if _, err := bw.Write(samples); err != nil {
+			return err
+		}
+	}
+
+	if dataSize%2 == 1 {
+		if err := bw.WriteByte(0); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// resampler produces output frames from a stream of source frames. When
+// downsampling, each output frame averages the source frames it covers,
+// which keeps most aliasing out of the preview; otherwise it
+// interpolates between the nearest two.
+type resampler struct {
+	channels int
+	// Source frames per output frame
+	ratio    float64
+	inFrames int64
+	read     func([]float64) error
+
+	// Decoded source frames, the first being frame base
+	buf  [][]float64
+	base int64
+}
+
+func newResampler(channels int, ratio float64, inFrames int64, read func([]float64) error) *resampler {
+	return &resampler{channels: channels, ratio: ratio, inFrames: inFrames, read: read}
+}
+
+// load makes sure source frames up to and including last are buffered.
+func (r *resampler) load(last int64) error {
+	last = min(last, r.inFrames-1)
+	for r.base+int64(len(r.buf)) <= last {
+		f := make([]float64, r.channels)
+		if err := r.read(f); err != nil {
+			return err
+		}
+		r.buf = append(r.buf, f)
+	}
+	return nil
+}
+
+// drop forgets source frames before first.
+func (r *resampler) drop(first int64) {
+	n := int(min(max(first-r.base, 0), int64(len(r.buf))))
+	if n == 0 {
+		return
+	}
+	r.buf = slices.Delete(r.buf, 0, n)
+	r.base += int64(n)
+}
+
+func (r *resampler) at(i int64) []float64 {
+	i = max(0, min(i, r.inFrames-1))
+	return r.buf[i-r.base]
+}
+
+func (r *resampler) frame(k int64, dst []float64) error {
+	center := (float64(k)+0.5)*r.ratio - 0.5
+
+	if r.ratio > 1 {
+		lo := int64(math.Ceil(center - r.ratio/2))
+		hi := int64(math.Floor(center + r.ratio/2))
+		lo = max(lo, 0)
+		hi = max(min(hi, r.inFrames-1), lo)
+
+		if err := r.load(hi); err != nil {
+			return err
+		}
+		r.drop(lo)
+
+		clear(dst)
+		for i := lo; i <= hi; i++ {
+			for c, v := range r.at(i) {
+				dst[c] += v
+			}
+		}
+		for c := range dst {
+			dst[c] /= float64(hi - lo + 1)
+		}
+		return nil
+	}
+
+	i := int64(math.Floor(center))
+	frac := center - float64(i)
+	if err := r.load(i + 1); err != nil {
+		return err
+	}
+	r.drop(i)
+
+	a, b := r.at(i), r.at(i+1)
+	for c := range dst {
+		dst[c] = a[c] + (b[c]-a[c])*frac
+	}
+	return nil
+}
@@ -52,26 +52,67 @@ func ReadVersion(path string) Version {
 }
 
 func ParseVersion(version string) Version {
-	versionStr := strings.TrimSpace(string(version))
+	v, err := ParseVersionStrict(strings.TrimSpace(version))
+	if err != nil {
+		panic(err)
+	}
 
-	versionParts := strings.Split(versionStr, ".")
+	return v
+}
 
-	if len(versionParts) != 2 {
-		panic("Invalid version format")
+// ParseVersionStrict parses a MAJOR.MINOR version made of nothing but
+// digits, returning an error instead of panicking. Numbers may not have
+// leading zeros, so each version has only one spelling.
+func ParseVersionStrict(version string) (Version, error) {
+	majorStr, minorStr, ok := strings.Cut(version, ".")
+	if !ok || !isVersionNumber(majorStr) || !isVersionNumber(minorStr) {
+		return Version{}, fmt.Errorf("invalid version %q, expected MAJOR.MINOR", version)
 	}
 
-	major, err := strconv.Atoi(versionParts[0])
+	major, err := strconv.Atoi(majorStr)
 	if err != nil {
-		panic(err)
+		return Version{}, err
 	}
 
-	minor, err := strconv.Atoi(versionParts[1])
+	minor, err := strconv.Atoi(minorStr)
 	if err != nil {
-		panic(err)
+		return Version{}, err
 	}
 
 	return Version{
 		Major: major,
 		Minor: minor,
+	}, nil
+}
+
+// VersionLess orders versions by number, so 0.2 comes before 0.10.
+// Strings that are not valid versions sort after every valid one, and
+// among themselves as bytes.
+func VersionLess(a, b string) bool {
+	va, erra := ParseVersionStrict(a)
+	vb, errb := ParseVersionStrict(b)
+	if erra != nil || errb != nil {
+		if erra != nil && errb != nil {
+			return a < b
+		}
+		return errb != nil
+	}
+
+	if va.Major != vb.Major {
+		return va.Major < vb.Major
+	}
+	return va.Minor < vb.Minor
+}
+
+// Nine digits always fit in an int.
+func isVersionNumber(s string) bool {
+	if len(s) == 0 || len(s) > 9 || (len(s) > 1 && s[0] == '0') {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
 	}
+	return true
 }
@@ -0,0 +1,83 @@
+package refs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseVersionStrict(t *testing.T) {
+	cases := []struct {
+		version string
+		want    Version
+		ok      bool
+	}{
+		{"0.1", Version{0, 1}, true},
+		{"1.0", Version{1, 0}, true},
+		{"12.345", Version{12, 345}, true},
+		{"999999999.999999999", Version{999999999, 999999999}, true},
+
+		{"", Version{}, false},
+		{"1", Version{}, false},
+		{"1.", Version{}, false},
+		{".1", Version{}, false},
+		{"1.2.3", Version{}, false},
+		{"01.2", Version{}, false},
+		{"1.02", Version{}, false},
+		{"00.1", Version{}, false},
+		{"+1.2", Version{}, false},
+		{"-1.2", Version{}, false},
+		{"1.2 ", Version{}, false},
+		{" 1.2", Version{}, false},
+		{"1e3.1", Version{}, false},
+		{"1.2/..", Version{}, false},
+		{"..", Version{}, false},
+		{"1000000000.1", Version{}, false},
+		{"١.٢", Version{}, false},
+	}
+
+	for _, c := range cases {
+		got, err := ParseVersionStrict(c.version)
+		if c.ok && (err != nil || got != c.want) {
+			t.Errorf("%q: got %v, %v, expected %v", c.version, got, err, c.want)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%q: got %v, expected an error", c.version, got)
+		}
+	}
+}
+
+func TestVersionLess(t *testing.T) {
+	ordered := []string{"0.1", "0.2", "0.10", "1.0", "1.2", "10.0", "01.2", "latest", "v1"}
+
+	for i, a := range ordered {
+		for j, b := range ordered {
+			if got := VersionLess(a, b); got != (i < j) {
+				t.Errorf("VersionLess(%q, %q) = %v, expected %v", a, b, got, i < j)
+			}
+		}
+	}
+}
+
+func FuzzParseVersionStrict(f *testing.F) {
+	for _, seed := range []string{"0.1", "12.345", "01.2", "1.2.3", "", "..", "999999999.999999999"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, version string) {
+		v, err := ParseVersionStrict(version)
+		if err != nil {
+			return
+		}
+
+		// A version that parses has exactly one spelling.
+		if v.String() != version {
+			t.Errorf("%q parsed as %v, which is spelled differently", version, v)
+		}
+		if v.Major < 0 || v.Minor < 0 {
+			t.Errorf("%q parsed as negative %v", version, v)
+		}
+		if strings.ContainsAny(version, "/\\") {
+			t.Errorf("%q parsed despite holding a separator", version)
+		}
+	})
+}
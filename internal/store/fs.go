@@ -0,0 +1,160 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"stewdio/internal/refs"
+	"stewdio/internal/utils"
+)
+
+// FSStore keeps everything under a single data directory:
+//
+//	{root}/projects/{project}/objects/{version}/lean.tar.gz
+type FSStore struct {
+	Root string
+}
+
+func NewFSStore(root string) (*FSStore, error) {
+	if err := os.MkdirAll(filepath.Join(root, "projects"), 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FSStore{Root: root}, nil
+}
+
+func (s *FSStore) projectDir(project string) string {
+	return filepath.Join(s.Root, "projects", project)
+}
+
+func (s *FSStore) pinDir(project string, version string) string {
+	return filepath.Join(s.projectDir(project), "objects", version)
+}
+
+func (s *FSStore) ListProjects() ([]string, error) {
+	return listDirs(filepath.Join(s.Root, "projects"))
+}
+
+func (s *FSStore) CreateProject(name string) error {
+	dir := s.projectDir(name)
+	if utils.PathExists(dir) {
+		return ErrExists
+	}
+
+	return os.MkdirAll(filepath.Join(dir, "objects"), 0o755)
+}
+
+func (s *FSStore) GetProject(name string) (ProjectInfo, error) {
+	info, err := os.Stat(s.projectDir(name))
+	if err != nil {
+		return ProjectInfo{}, notFound(err)
+	}
+
+	return ProjectInfo{
+		Name:         name,
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+func (s *FSStore) DeleteProject(name string) error {
+	dir := s.projectDir(name)
+	if !utils.PathExists(dir) {
+		return ErrNotFound
+	}
+
+	return os.RemoveAll(dir)
+}
+
+func (s *FSStore) ListPins(project string) ([]string, error) {
+	if !utils.PathExists(s.projectDir(project)) {
+		return nil, ErrNotFound
+	}
+
+	versions, err := listDirs(filepath.Join(s.projectDir(project), "objects"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	return versions, err
+}
+
+func (s *FSStore) PutPin(project string, version string, archive io.Reader) error {
+	// A pin that fails to upload should not leave behind the project
+	// it would have created.
+	newProject := !utils.PathExists(s.projectDir(project))
+
+	objectsDir := filepath.Join(s.projectDir(project), "objects")
+	if err := os.MkdirAll(objectsDir, 0o755); err != nil {
+		return err
+	}
+
+	dir := s.pinDir(project, version)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		if os.IsExist(err) {
+			return ErrExists
+		}
+		return err
+	}
+
+	err := utils.WriteFileAtomic(filepath.Join(dir, refs.ObjectTarName), 0o644, func(w io.Writer) error {
+		_, err := io.Copy(w, archive)
+		return err
+	})
+	if err != nil {
+		if newProject {
+			_ = os.RemoveAll(s.projectDir(project))
+		} else {
+			_ = os.RemoveAll(dir)
+		}
+		return fmt.Errorf("failed to write pin: %w", err)
+	}
+
+	// Bump the project's modification time to the latest pin.
+	now := time.Now()
+	_ = os.Chtimes(s.projectDir(project), now, now)
+
+	return nil
+}
+
+func (s *FSStore) OpenPin(project string, version string) (io.ReadSeekCloser, PinInfo, error) {
+	f, err := os.Open(filepath.Join(s.pinDir(project, version), refs.ObjectTarName))
+	if err != nil {
+		return nil, PinInfo{}, notFound(err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, PinInfo{}, err
+	}
+
+	return f, PinInfo{Size: info.Size(), Created: info.ModTime()}, nil
+}
+
+func listDirs(path string) ([]string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return names, nil
+}
+
+func notFound(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps everything in memory. It is meant for tests.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	projects map[string]*memoryProject
+}
+
+type memoryProject struct {
+	modified time.Time
+	pins     map[string]memoryPin
+}
+
+type memoryPin struct {
+	data    []byte
+	created time.Time
+}
+
+type memoryPinReader struct {
+	*bytes.Reader
+}
+
+func (memoryPinReader) Close() error {
+	return nil
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		projects: make(map[string]*memoryProject),
+	}
+}
+
+func (s *MemoryStore) ListProjects() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var names []string
+	for name := range s.projects {
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func (s *MemoryStore) CreateProject(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[name]; ok {
+		return ErrExists
+	}
+	s.projects[name] = newMemoryProject()
+
+	return nil
+}
+
+func (s *MemoryStore) GetProject(name string) (ProjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.projects[name]
+	if !ok {
+		return ProjectInfo{}, ErrNotFound
+	}
+
+	return ProjectInfo{
+		Name:         name,
+		LastModified: p.modified,
+	}, nil
+}
+
+func (s *MemoryStore) DeleteProject(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.projects[name]; !ok {
+		return ErrNotFound
+	}
+	delete(s.projects, name)
+
+	return nil
+}
+
+func (s *MemoryStore) ListPins(project string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.projects[project]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	var versions []string
+	for version := range p.pins {
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+func (s *MemoryStore) PutPin(project string, version string, archive io.Reader) error {
+	data, err := io.ReadAll(archive)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.projects[project]
+	if !ok {
+		p = newMemoryProject()
+		s.projects[project] = p
+	}
+
+	if _, ok := p.pins[version]; ok {
+		return ErrExists
+	}
+	p.modified = time.Now()
+	p.pins[version] = memoryPin{data: data, created: p.modified}
+
+	return nil
+}
+
+func (s *MemoryStore) OpenPin(project string, version string) (io.ReadSeekCloser, PinInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.projects[project]
+	if !ok {
+		return nil, PinInfo{}, ErrNotFound
+	}
+
+	pin, ok := p.pins[version]
+	if !ok {
+		return nil, PinInfo{}, ErrNotFound
+	}
+
+	info := PinInfo{Size: int64(len(pin.data)), Created: pin.created}
+	return memoryPinReader{bytes.NewReader(pin.data)}, info, nil
+}
+
+func newMemoryProject() *memoryProject {
+	return &memoryProject{
+		modified: time.Now(),
+		pins:     make(map[string]memoryPin),
+	}
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"stewdio/internal/refs"
+)
+
+type S3Options struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Prepended to every object key, for sharing a bucket
+	Prefix string
+	UseSSL bool
+}
+
+// S3Store keeps projects in any S3-compatible bucket, using the same
+// layout as FSStore:
+//
+//	{prefix}projects/{project}/objects/{version}/lean.tar.gz
+//
+// S3 has no directories, so each project also gets a marker object that
+// records its existence and last modification.
+type S3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+const projectMarker = ".project"
+
+// Pins are streamed up in parts of this size. The client buffers a
+// whole part in memory, and with no size given would otherwise pick
+// parts large enough for a 5 TiB object.
+const pinPartSize = 16 << 20
+
+func NewS3Store(opts S3Options) (*S3Store, error) {
+	if opts.Endpoint == "" || opts.Bucket == "" {
+		return nil, fmt.Errorf("s3 storage needs an endpoint and a bucket")
+	}
+
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure: opts.UseSSL,
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+
+	exists, err := client.BucketExists(context.Background(), opts.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach bucket %s: %w", opts.Bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %s does not exist", opts.Bucket)
+	}
+
+	prefix := opts.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &S3Store{
+		client: client,
+		bucket: opts.Bucket,
+		prefix: prefix,
+	}, nil
+}
+
+func (s *S3Store) projectKey(project string) string {
+	return s.prefix + path.Join("projects", project) + "/"
+}
+
+func (s *S3Store) markerKey(project string) string {
+	return s.projectKey(project) + projectMarker
+}
+
+func (s *S3Store) pinKey(project string, version string) string {
+	return s.projectKey(project) + path.Join("objects", version, refs.ObjectTarName)
+}
+
+func (s *S3Store) ListProjects() ([]string, error) {
+	return s.listPrefixes(s.prefix + "projects/")
+}
+
+func (s *S3Store) CreateProject(name string) error {
+	ctx := context.Background()
+
+	if _, err := s.client.StatObject(ctx, s.bucket, s.markerKey(name), minio.StatObjectOptions{}); err == nil {
+		return ErrExists
+	} else if !isNoSuchKey(err) {
+		return err
+	}
+
+	return s.touchProject(ctx, name)
+}
+
+func (s *S3Store) GetProject(name string) (ProjectInfo, error) {
+	info, err := s.client.StatObject(context.Background(), s.bucket, s.markerKey(name), minio.StatObjectOptions{})
+	if err != nil {
+		return ProjectInfo{}, s3NotFound(err)
+	}
+
+	return ProjectInfo{
+		Name:         name,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+func (s *S3Store) DeleteProject(name string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := s.client.StatObject(ctx, s.bucket, s.markerKey(name), minio.StatObjectOptions{}); err != nil {
+		return s3NotFound(err)
+	}
+
+	objects := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.projectKey(name),
+		Recursive: true,
+	})
+
+	for rerr := range s.client.RemoveObjects(ctx, s.bucket, objects, minio.RemoveObjectsOptions{}) {
+		return fmt.Errorf("failed to remove %s: %w", rerr.ObjectName, rerr.Err)
+	}
+
+	return nil
+}
+
+func (s *S3Store) ListPins(project string) ([]string, error) {
+	if _, err := s.client.StatObject(context.Background(), s.bucket, s.markerKey(project), minio.StatObjectOptions{}); err != nil {
+		return nil, s3NotFound(err)
+	}
+
+	return s.listPrefixes(s.projectKey(project) + "objects/")
+}
+
+func (s *S3Store) PutPin(project string, version string, archive io.Reader) error {
+	ctx := context.Background()
+	key := s.pinKey(project, version)
+
+	if _, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{}); err == nil {
+		return ErrExists
+	} else if !isNoSuchKey(err) {
+		return err
+	}
+
+	opts := minio.PutObjectOptions{
+		ContentType: "application/gzip",
+		PartSize:    pinPartSize,
+		// Callers verify the archive's SHA-256 as it is read, so parts
+		// are sent unsigned as they are over TLS, rather than hashed a
+		// second time.
+		DisableContentSha256: true,
+	}
+	// Pins are immutable, so refuse to replace one that was written
+	// while this upload was in flight.
+	opts.SetMatchETagExcept("*")
+
+	if _, err := s.client.PutObject(ctx, s.bucket, key, archive, -1, opts); err != nil {
+		if resp := minio.ToErrorResponse(err); resp.StatusCode == http.StatusPreconditionFailed {
+			return ErrExists
+		}
+		return fmt.Errorf("failed to upload pin: %w", err)
+	}
+
+	return s.touchProject(ctx, project)
+}
+
+func (s *S3Store) OpenPin(project string, version string) (io.ReadSeekCloser, PinInfo, error) {
+	ctx := context.Background()
+
+	obj, err := s.client.GetObject(ctx, s.bucket, s.pinKey(project, version), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, PinInfo{}, s3NotFound(err)
+	}
+
+	// GetObject is lazy; Stat makes the request so a missing pin is
+	// reported here rather than on the first read.
+	info, err := obj.Stat()
+	if err != nil {
+		_ = obj.Close()
+		return nil, PinInfo{}, s3NotFound(err)
+	}
+
+	return obj, PinInfo{Size: info.Size, Created: info.LastModified}, nil
+}
+
+// touchProject (re)writes the project marker, which bumps its
+// modification time.
+func (s *S3Store) touchProject(ctx context.Context, project string) error {
+	// There is no payload to sign, and over plain HTTP the client would
+	// otherwise send the empty body chunked, which S3 rejects.
+	opts := minio.PutObjectOptions{DisableContentSha256: true}
+	_, err := s.client.PutObject(ctx, s.bucket, s.markerKey(project), strings.NewReader(""), 0, opts)
+	return err
+}
+
+// listPrefixes returns the names of the "directories" directly below
+// prefix.
+func (s *S3Store) listPrefixes(prefix string) ([]string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var names []string
+
+	objects := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix: prefix,
+	})
+	for obj := range objects {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if strings.HasSuffix(obj.Key, "/") {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(obj.Key, prefix), "/"))
+		}
+	}
+
+	return names, nil
+}
+
+func isNoSuchKey(err error) bool {
+	resp := minio.ToErrorResponse(err)
+	return resp.Code == "NoSuchKey" || resp.StatusCode == http.StatusNotFound
+}
+
+func s3NotFound(err error) error {
+	if isNoSuchKey(err) {
+		return ErrNotFound
+	}
+	return err
+}
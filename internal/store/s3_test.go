@@ -0,0 +1,101 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// newFakeS3Store serves an empty in-memory bucket over the S3 API and
+// returns an S3Store using it.
+func newFakeS3Store(t *testing.T, prefix string) *S3Store {
+	t.Helper()
+
+	backend := s3mem.New()
+	if err := backend.CreateBucket("stewdio"); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(server.Close)
+
+	endpoint, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewS3Store(S3Options{
+		Endpoint:  endpoint.Host,
+		Bucket:    "stewdio",
+		Region:    "us-east-1",
+		AccessKey: "test",
+		SecretKey: "test",
+		Prefix:    prefix,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestS3Store(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return newFakeS3Store(t, "")
+	})
+}
+
+func TestS3StorePrefix(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return newFakeS3Store(t, "shared/stewdio")
+	})
+}
+
+func TestS3StoreMultipart(t *testing.T) {
+	s := newFakeS3Store(t, "")
+
+	// Larger than one part, and not a whole number of them
+	archive := make([]byte, pinPartSize+pinPartSize/3)
+	rng := rand.New(rand.NewPCG(7, 8))
+	for i := range archive {
+		archive[i] = byte(rng.Uint32())
+	}
+
+	if err := s.PutPin("song", "0.1", bytes.NewReader(archive)); err != nil {
+		t.Fatal(err)
+	}
+
+	r, info, err := s.OpenPin("song", "0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(archive)) || !bytes.Equal(data, archive) {
+		t.Errorf("read back %d bytes that differ from the %d stored", len(data), len(archive))
+	}
+}
+
+func TestS3StoreMissingBucket(t *testing.T) {
+	server := httptest.NewServer(gofakes3.New(s3mem.New()).Server())
+	defer server.Close()
+
+	endpoint, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewS3Store(S3Options{Endpoint: endpoint.Host, Bucket: "missing", AccessKey: "test", SecretKey: "test"})
+	if err == nil {
+		t.Fatal("expected an error for a missing bucket")
+	}
+}
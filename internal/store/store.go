@@ -0,0 +1,185 @@
+package store
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrExists   = errors.New("already exists")
+)
+
+type ProjectInfo struct {
+	Name         string
+	LastModified time.Time
+}
+
+type PinInfo struct {
+	Size int64
+	// When the pin was stored. Pins never change afterwards.
+	Created time.Time
+}
+
+// Store holds projects and the pin archives uploaded to them.
+type Store interface {
+	ListProjects() ([]string, error)
+	CreateProject(name string) error
+	GetProject(name string) (ProjectInfo, error)
+	DeleteProject(name string) error
+
+	// ListPins returns the versions pinned in a project, in no
+	// particular order.
+	ListPins(project string) ([]string, error)
+	// PutPin stores a pin archive, creating the project if needed.
+	PutPin(project string, version string, archive io.Reader) error
+	OpenPin(project string, version string) (io.ReadSeekCloser, PinInfo, error)
+}
+
+type FileInfo struct {
+	Size int64
+	// The pin the file was read from
+	Pin PinInfo
+}
+
+// OpenPinFile opens a single tracked file from inside a pin archive.
+func OpenPinFile(s Store, project string, version string, file string) (io.ReadCloser, FileInfo, error) {
+	pin, pinInfo, err := s.OpenPin(project, version)
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+
+	gz, err := gzip.NewReader(pin)
+	if err != nil {
+		_ = pin.Close()
+		return nil, FileInfo{}, fmt.Errorf("failed to read gzip: %w", err)
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			_ = gz.Close()
+			_ = pin.Close()
+			return nil, FileInfo{}, ErrNotFound
+		}
+		if err != nil {
+			_ = gz.Close()
+			_ = pin.Close()
+			return nil, FileInfo{}, fmt.Errorf("failed to read tar: %w", err)
+		}
+
+		if hdr.Name == "files/"+file {
+			info := FileInfo{Size: hdr.Size, Pin: pinInfo}
+			return &archiveFile{Reader: tr, closers: []io.Closer{gz, pin}}, info, nil
+		}
+	}
+}
+
+type archiveFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (f *archiveFile) Close() error {
+	var err error
+	for _, c := range f.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// OpenPinFileSeeker is OpenPinFile for callers that need to seek, such
+// as when serving ranges. The archive is compressed, so seeking forward
+// decompresses and skips the data in between, and seeking backward
+// opens the file again.
+func OpenPinFileSeeker(s Store, project string, version string, file string) (io.ReadSeekCloser, FileInfo, error) {
+	r, info, err := OpenPinFile(s, project, version, file)
+	if err != nil {
+		return nil, info, err
+	}
+
+	seeker := &pinFileSeeker{
+		open: func() (io.ReadCloser, error) {
+			r, _, err := OpenPinFile(s, project, version, file)
+			return r, err
+		},
+		size: info.Size,
+		r:    r,
+	}
+	return seeker, info, nil
+}
+
+type pinFileSeeker struct {
+	open func() (io.ReadCloser, error)
+	size int64
+
+	// Position reads will continue from
+	pos int64
+	// Underlying reader and its position
+	r    io.ReadCloser
+	rpos int64
+}
+
+func (f *pinFileSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return f.pos, fmt.Errorf("invalid whence %d", whence)
+	}
+
+	if offset < 0 {
+		return f.pos, fmt.Errorf("negative seek position")
+	}
+
+	f.pos = offset
+	return f.pos, nil
+}
+
+func (f *pinFileSeeker) Read(p []byte) (int, error) {
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+
+	if f.r != nil && f.rpos > f.pos {
+		_ = f.r.Close()
+		f.r = nil
+	}
+	if f.r == nil {
+		r, err := f.open()
+		if err != nil {
+			return 0, err
+		}
+		f.r, f.rpos = r, 0
+	}
+
+	if f.rpos < f.pos {
+		skipped, err := io.CopyN(io.Discard, f.r, f.pos-f.rpos)
+		f.rpos += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.r.Read(p)
+	f.pos += int64(n)
+	f.rpos += int64(n)
+	return n, err
+}
+
+func (f *pinFileSeeker) Close() error {
+	if f.r == nil {
+		return nil
+	}
+	return f.r.Close()
+}
@@ -0,0 +1,248 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"stewdio/internal/testutil"
+)
+
+// testStore runs the behavior every Store backend must share against
+// stores made by newStore, each of which starts out empty.
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	t.Run("projects", func(t *testing.T) {
+		s := newStore(t)
+
+		projects, err := s.ListProjects()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(projects) != 0 {
+			t.Fatalf("new store lists projects %v", projects)
+		}
+
+		if err := s.CreateProject("song"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.CreateProject("other"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.CreateProject("song"); !errors.Is(err, ErrExists) {
+			t.Errorf("creating a project twice: got %v, expected ErrExists", err)
+		}
+
+		projects, err = s.ListProjects()
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(projects)
+		if !slices.Equal(projects, []string{"other", "song"}) {
+			t.Errorf("got projects %v, expected [other song]", projects)
+		}
+
+		info, err := s.GetProject("song")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name != "song" || info.LastModified.IsZero() {
+			t.Errorf("got project info %+v", info)
+		}
+
+		versions, err := s.ListPins("song")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versions) != 0 {
+			t.Errorf("new project lists pins %v", versions)
+		}
+
+		if err := s.DeleteProject("song"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetProject("song"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("getting a deleted project: got %v, expected ErrNotFound", err)
+		}
+		if _, err := s.ListPins("song"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("listing pins of a deleted project: got %v, expected ErrNotFound", err)
+		}
+		if err := s.DeleteProject("song"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("deleting a deleted project: got %v, expected ErrNotFound", err)
+		}
+
+		projects, err = s.ListProjects()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(projects, []string{"other"}) {
+			t.Errorf("got projects %v after deleting song, expected [other]", projects)
+		}
+	})
+
+	t.Run("pins", func(t *testing.T) {
+		s := newStore(t)
+		archive := testutil.Archive(t, map[string]string{"message": "first"})
+
+		// Pinning into a project that does not exist yet creates it.
+		if err := s.PutPin("song", "0.1", bytes.NewReader(archive)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetProject("song"); err != nil {
+			t.Fatalf("project was not created by its first pin: %v", err)
+		}
+
+		if err := s.PutPin("song", "0.1", bytes.NewReader(archive)); !errors.Is(err, ErrExists) {
+			t.Errorf("storing a pin twice: got %v, expected ErrExists", err)
+		}
+
+		before, err := s.GetProject("song")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.PutPin("song", "0.2", bytes.NewReader(archive)); err != nil {
+			t.Fatal(err)
+		}
+		after, err := s.GetProject("song")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after.LastModified.Before(before.LastModified) {
+			t.Errorf("pinning moved the project's modification time back from %v to %v", before.LastModified, after.LastModified)
+		}
+
+		versions, err := s.ListPins("song")
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(versions)
+		if !slices.Equal(versions, []string{"0.1", "0.2"}) {
+			t.Errorf("got pins %v, expected [0.1 0.2]", versions)
+		}
+
+		r, info, err := s.OpenPin("song", "0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = r.Close() }()
+
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, archive) {
+			t.Error("pin read back differs from what was stored")
+		}
+		if info.Size != int64(len(archive)) {
+			t.Errorf("pin size is %d, expected %d", info.Size, len(archive))
+		}
+		if info.Created.IsZero() || time.Since(info.Created) > time.Hour {
+			t.Errorf("pin creation time %v is not recent", info.Created)
+		}
+
+		if _, err := r.Seek(10, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		rest, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rest, archive[10:]) {
+			t.Error("reading after a seek gave the wrong bytes")
+		}
+
+		if _, _, err := s.OpenPin("song", "9.9"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("opening a missing pin: got %v, expected ErrNotFound", err)
+		}
+		if _, _, err := s.OpenPin("missing", "0.1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("opening a pin in a missing project: got %v, expected ErrNotFound", err)
+		}
+	})
+
+	t.Run("failed upload", func(t *testing.T) {
+		s := newStore(t)
+		archive := testutil.Archive(t, map[string]string{"message": "first"})
+		wrongHash := hex.EncodeToString(make([]byte, sha256.Size))
+
+		r, err := VerifyReader(bytes.NewReader(archive), wrongHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.PutPin("song", "0.1", r); !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("storing a corrupted pin: got %v, expected ErrChecksumMismatch", err)
+		}
+
+		// Neither the pin nor the project it would have created exist.
+		if _, _, err := s.OpenPin("song", "0.1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("opening a failed pin: got %v, expected ErrNotFound", err)
+		}
+		if _, err := s.GetProject("song"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("getting a project only a failed pin made: got %v, expected ErrNotFound", err)
+		}
+
+		// The version can still be stored afterwards.
+		if err := s.PutPin("song", "0.1", bytes.NewReader(archive)); err != nil {
+			t.Fatalf("storing a pin after a failed attempt: %v", err)
+		}
+	})
+
+	t.Run("pin files", func(t *testing.T) {
+		s := newStore(t)
+		audio := bytes.Repeat([]byte("0123456789"), 1000)
+		archive := testutil.Archive(t, map[string]string{
+			"message":        "first",
+			"files/kick.wav": "kick",
+			"files/bass.wav": string(audio),
+		})
+		if err := s.PutPin("song", "0.1", bytes.NewReader(archive)); err != nil {
+			t.Fatal(err)
+		}
+
+		f, info, err := OpenPinFileSeeker(s, "song", "0.1", "bass.wav")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+		if info.Size != int64(len(audio)) {
+			t.Errorf("file size is %d, expected %d", info.Size, len(audio))
+		}
+
+		// Forward, backward, and from the end
+		for _, offset := range []int64{5000, 20, 9990} {
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 10)
+			if _, err := io.ReadFull(f, buf); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf, audio[offset:offset+10]) {
+				t.Errorf("at %d read %q, expected %q", offset, buf, audio[offset:offset+10])
+			}
+		}
+
+		if _, _, err := OpenPinFile(s, "song", "0.1", "missing.wav"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("opening a missing file: got %v, expected ErrNotFound", err)
+		}
+	})
+}
+
+func TestFSStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		s, err := NewFSStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return NewMemoryStore()
+	})
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// VerifyReader passes r through, and in place of io.EOF returns
+// ErrChecksumMismatch if the data read does not hash to the expected
+// SHA-256. Stores only commit a pin after reading it to EOF, so a
+// mismatch makes PutPin fail without storing anything.
+func VerifyReader(r io.Reader, expected string) (io.Reader, error) {
+	want, err := hex.DecodeString(expected)
+	if err != nil || len(want) != sha256.Size {
+		return nil, fmt.Errorf("invalid sha256 %q", expected)
+	}
+
+	return &verifyingReader{r: r, hash: sha256.New(), want: want}, nil
+}
+
+type verifyingReader struct {
+	r    io.Reader
+	hash hash.Hash
+	want []byte
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+
+	if err == io.EOF {
+		if got := v.hash.Sum(nil); !bytes.Equal(got, v.want) {
+			return n, fmt.Errorf("%w: got %x, expected %x", ErrChecksumMismatch, got, v.want)
+		}
+	}
+
+	return n, err
+}
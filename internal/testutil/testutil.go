@@ -0,0 +1,64 @@
+// Package testutil holds helpers shared by tests in several packages.
+package testutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"slices"
+	"testing"
+
+	"stewdio/internal/wavfile"
+)
+
+// Archive builds a gzipped tar holding files, the way pins are stored.
+func Archive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// WAV returns a WAV file holding data in format. An odd-sized data
+// chunk is followed by a pad byte, as RIFF requires.
+func WAV(format wavfile.Format, data []byte) []byte {
+	contents := append(wavfile.Header(format, int64(len(data))), data...)
+	if len(data)%2 == 1 {
+		contents = append(contents, 0)
+	}
+	return contents
+}
+
+// WriteWAV writes a WAV file holding data in format to path.
+func WriteWAV(t *testing.T, path string, format wavfile.Format, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, WAV(format, data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
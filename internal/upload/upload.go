@@ -0,0 +1,294 @@
+// Package upload keeps resumable pin uploads while their chunks arrive.
+//
+// A session records what is being uploaded and appends chunks to a data
+// file in order, so the bytes received so far are always a prefix of
+// the archive and resuming only needs that prefix's length.
+package upload
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"stewdio/internal/utils"
+)
+
+const (
+	ChunkSize = 8 << 20
+	// Sessions untouched for this long are removed
+	Expiry = 24 * time.Hour
+)
+
+var (
+	ErrNotFound      = errors.New("upload session not found")
+	ErrOutOfOrder    = errors.New("chunk out of order")
+	ErrChunkSize     = errors.New("wrong chunk size")
+	ErrIncomplete    = errors.New("upload is incomplete")
+	ErrInvalidLength = errors.New("invalid upload size")
+)
+
+type Session struct {
+	ID        string    `json:"id"`
+	Project   string    `json:"project"`
+	Version   string    `json:"version"`
+	Parent    string    `json:"parent"`
+	User      string    `json:"user"`
+	SHA256    string    `json:"sha256"`
+	Size      int64     `json:"size"`
+	ChunkSize int64     `json:"chunkSize"`
+	Created   time.Time `json:"created"`
+}
+
+func (s Session) Chunks() int64 {
+	return (s.Size + s.ChunkSize - 1) / s.ChunkSize
+}
+
+// chunkLength is how many bytes chunk n must hold; only the last chunk
+// may be short.
+func (s Session) chunkLength(n int64) int64 {
+	return min(s.ChunkSize, s.Size-n*s.ChunkSize)
+}
+
+type Sessions struct {
+	Dir string
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func NewSessions(dir string) (*Sessions, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &Sessions{Dir: dir, locks: map[string]*sync.Mutex{}}
+	s.expire()
+
+	return s, nil
+}
+
+func (s *Sessions) lock(id string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[id] = l
+	}
+	s.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+func (s *Sessions) dir(id string) (string, error) {
+	if !validID(id) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.Dir, id), nil
+}
+
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// Create starts a session for an archive of the given size.
+func (s *Sessions) Create(session Session) (Session, error) {
+	if session.Size <= 0 {
+		return Session{}, ErrInvalidLength
+	}
+
+	s.expire()
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Session{}, err
+	}
+	session.ID = hex.EncodeToString(id)
+	session.ChunkSize = ChunkSize
+	session.Created = time.Now().UTC()
+
+	dir := filepath.Join(s.Dir, session.ID)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return Session{}, err
+	}
+
+	sessionBytes, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return Session{}, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "session.json"), sessionBytes, 0o644); err != nil {
+		_ = os.RemoveAll(dir)
+		return Session{}, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data"), nil, 0o644); err != nil {
+		_ = os.RemoveAll(dir)
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+// Get returns a session and how many bytes of it have been received.
+func (s *Sessions) Get(id string) (Session, int64, error) {
+	dir, err := s.dir(id)
+	if err != nil {
+		return Session{}, 0, err
+	}
+
+	unlock := s.lock(id)
+	defer unlock()
+
+	return readSession(dir)
+}
+
+func readSession(dir string) (Session, int64, error) {
+	var session Session
+
+	sessionBytes, err := os.ReadFile(filepath.Join(dir, "session.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return session, 0, ErrNotFound
+		}
+		return session, 0, err
+	}
+	if err := json.Unmarshal(sessionBytes, &session); err != nil {
+		return session, 0, fmt.Errorf("invalid upload session: %w", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "data"))
+	if err != nil {
+		return session, 0, err
+	}
+
+	return session, info.Size(), nil
+}
+
+// WriteChunk appends chunk n, which must be the next one expected. A
+// chunk that was already received is accepted again without rewriting
+// it, since the client cannot tell whether its last attempt arrived.
+// It returns the received offset afterwards.
+func (s *Sessions) WriteChunk(id string, n int64, length int64, r io.Reader) (int64, error) {
+	dir, err := s.dir(id)
+	if err != nil {
+		return 0, err
+	}
+
+	unlock := s.lock(id)
+	defer unlock()
+
+	session, offset, err := readSession(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	if n < 0 || n >= session.Chunks() {
+		return offset, ErrOutOfOrder
+	}
+	if length != session.chunkLength(n) {
+		return offset, ErrChunkSize
+	}
+
+	start := n * session.ChunkSize
+	if start+length <= offset {
+		return offset, nil
+	}
+	if start != offset {
+		return offset, ErrOutOfOrder
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, "data"), os.O_WRONLY, 0)
+	if err != nil {
+		return offset, err
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	// A chunk is all or nothing, so the offset stays on a chunk boundary.
+	if _, err := io.CopyN(f, r, length); err != nil {
+		_ = f.Truncate(start)
+		return offset, fmt.Errorf("failed to receive chunk %d: %w", n, err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Truncate(start)
+		return offset, err
+	}
+
+	return start + length, nil
+}
+
+// Open returns the received archive of a complete session.
+func (s *Sessions) Open(id string) (io.ReadCloser, error) {
+	dir, err := s.dir(id)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := s.lock(id)
+	defer unlock()
+
+	session, offset, err := readSession(dir)
+	if err != nil {
+		return nil, err
+	}
+	if offset != session.Size {
+		return nil, ErrIncomplete
+	}
+
+	return os.Open(filepath.Join(dir, "data"))
+}
+
+func (s *Sessions) Remove(id string) error {
+	dir, err := s.dir(id)
+	if err != nil {
+		return err
+	}
+
+	unlock := s.lock(id)
+	defer unlock()
+
+	s.mu.Lock()
+	delete(s.locks, id)
+	s.mu.Unlock()
+
+	if !utils.PathExists(dir) {
+		return ErrNotFound
+	}
+	return os.RemoveAll(dir)
+}
+
+// expire removes sessions that have not received data in a while.
+func (s *Sessions) expire() {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !validID(entry.Name()) {
+			continue
+		}
+
+		// A session still being created has no data file yet.
+		info, err := os.Stat(filepath.Join(s.Dir, entry.Name(), "data"))
+		if err != nil {
+			info, err = entry.Info()
+		}
+		if err == nil && time.Since(info.ModTime()) > Expiry {
+			_ = s.Remove(entry.Name())
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Package validate checks names that arrive from clients before they are
+// used to build storage paths or keys.
+package validate
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"stewdio/internal/refs"
+)
+
+const (
+	maxNameLength = 128
+	maxPathLength = 1024
+)
+
+// Name checks a project or user name: 1 to 128 letters, digits, '.', '_'
+// or '-', starting with a letter or digit. That rules out "." and "..",
+// and any path separator.
+func Name(name string) error {
+	if len(name) == 0 || len(name) > maxNameLength {
+		return fmt.Errorf("name must be 1 to %d characters long", maxNameLength)
+	}
+
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case i > 0 && (c == '.' || c == '_' || c == '-'):
+		default:
+			return fmt.Errorf("invalid name %q, use letters, digits, '.', '_' and '-'", name)
+		}
+	}
+
+	return nil
+}
+
+// Version checks a pin version.
+func Version(version string) error {
+	_, err := refs.ParseVersionStrict(version)
+	return err
+}
+
+// FilePath checks the path of a file inside a pin: relative, in clean
+// form with '/' separators, and never stepping outside its root.
+func FilePath(p string) error {
+	if len(p) == 0 || len(p) > maxPathLength {
+		return fmt.Errorf("file path must be 1 to %d characters long", maxPathLength)
+	}
+	if strings.ContainsAny(p, "\\\x00") {
+		return fmt.Errorf("invalid file path %q", p)
+	}
+	if path.IsAbs(p) {
+		return fmt.Errorf("file path %q must be relative", p)
+	}
+	if path.Clean(p) != p {
+		return fmt.Errorf("file path %q is not in clean form", p)
+	}
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return fmt.Errorf("file path %q leaves the project", p)
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package validate
+
+import (
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestName(t *testing.T) {
+	cases := []struct {
+		name string
+		ok   bool
+	}{
+		{"song", true},
+		{"Song-2_final.v3", true},
+		{"a", true},
+		{"9lives", true},
+		{strings.Repeat("a", maxNameLength), true},
+
+		{"", false},
+		{strings.Repeat("a", maxNameLength+1), false},
+		{".", false},
+		{"..", false},
+		{".hidden", false},
+		{"-flag", false},
+		{"_song", false},
+		{"a/b", false},
+		{"a\\b", false},
+		{"a b", false},
+		{"a\x00b", false},
+		{"café", false},
+		{"song%2f..", false},
+	}
+
+	for _, c := range cases {
+		err := Name(c.name)
+		if c.ok && err != nil {
+			t.Errorf("%q: %v", c.name, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%q: expected an error", c.name)
+		}
+	}
+}
+
+func TestFilePath(t *testing.T) {
+	cases := []struct {
+		path string
+		ok   bool
+	}{
+		{"kick.wav", true},
+		{"stems/drums/kick.wav", true},
+		{"..kick.wav", true},
+		{"a/..b/c", true},
+
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../kick.wav", false},
+		{"stems/../../kick.wav", false},
+		{"stems/../kick.wav", false},
+		{"/etc/passwd", false},
+		{"stems//kick.wav", false},
+		{"stems/./kick.wav", false},
+		{"stems/", false},
+		{"stems\\kick.wav", false},
+		{"kick\x00.wav", false},
+		{strings.Repeat("a", maxPathLength+1), false},
+	}
+
+	for _, c := range cases {
+		err := FilePath(c.path)
+		if c.ok && err != nil {
+			t.Errorf("%q: %v", c.path, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%q: expected an error", c.path)
+		}
+	}
+}
+
+func FuzzName(f *testing.F) {
+	for _, seed := range []string{"song", "..", "a/b", ".hidden", "Song-2_final.v3", ""} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		if Name(name) != nil {
+			return
+		}
+
+		// A valid name is a single path element naming itself.
+		if path.Base(name) != name || path.Clean(name) != name || name == "." || name == ".." {
+			t.Errorf("%q is not a plain path element", name)
+		}
+		if strings.ContainsAny(name, "/\\\x00") {
+			t.Errorf("%q holds a separator", name)
+		}
+	})
+}
+
+func FuzzFilePath(f *testing.F) {
+	for _, seed := range []string{"kick.wav", "stems/kick.wav", "../x", "/abs", "a//b", ".", ""} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, p string) {
+		if FilePath(p) != nil {
+			return
+		}
+
+		// Joined under any root, a valid path stays inside it.
+		joined := path.Join("/root", p)
+		if !strings.HasPrefix(joined, "/root/") {
+			t.Errorf("%q escapes its root as %q", p, joined)
+		}
+		if strings.ContainsAny(p, "\\\x00") {
+			t.Errorf("%q holds a backslash or NUL", p)
+		}
+	})
+}
@@ -0,0 +1,291 @@
+// Package wavfile reads the RIFF container layout of WAV files, so that
+// sample data can be edited without disturbing the surrounding chunks.
+package wavfile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	riffHeaderSize  = 12
+	chunkHeaderSize = 8
+)
+
+var (
+	ErrNotWAV   = errors.New("not a RIFF/WAVE file")
+	ErrNoFormat = errors.New("missing fmt chunk")
+	ErrNoData   = errors.New("missing data chunk")
+)
+
+type Chunk struct {
+	ID string
+	// Offset of the chunk header from the start of the file
+	Offset int64
+	// Size of the chunk payload, excluding the header and pad byte
+	Size int64
+}
+
+func (c Chunk) DataOffset() int64 {
+	return c.Offset + chunkHeaderSize
+}
+
+// End is the offset just past the chunk, including its pad byte.
+func (c Chunk) End() int64 {
+	return c.DataOffset() + c.Size + c.Size%2
+}
+
+type Format struct {
+	AudioFormat   uint16 `json:"audioFormat"`
+	Channels      uint16 `json:"channels"`
+	SampleRate    uint32 `json:"sampleRate"`
+	ByteRate      uint32 `json:"byteRate"`
+	BlockAlign    uint16 `json:"blockAlign"`
+	BitsPerSample uint16 `json:"bitsPerSample"`
+}
+
+type File struct {
+	Size   int64
+	Chunks []Chunk
+	Format Format
+	// Index of the data chunk in Chunks
+	DataIndex int
+}
+
+func (f *File) Data() Chunk {
+	return f.Chunks[f.DataIndex]
+}
+
+// Parse walks the top-level chunks of a WAV file.
+func Parse(r io.ReaderAt, size int64) (*File, error) {
+	header := make([]byte, riffHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, ErrNotWAV
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, ErrNotWAV
+	}
+
+	f := &File{Size: size, DataIndex: -1}
+	haveFormat := false
+
+	chunkHeader := make([]byte, chunkHeaderSize)
+	for offset := int64(riffHeaderSize); offset+chunkHeaderSize <= size; {
+		if _, err := r.ReadAt(chunkHeader, offset); err != nil {
+			return nil, fmt.Errorf("failed to read chunk header at %d: %w", offset, err)
+		}
+
+		chunk := Chunk{
+			ID:     string(chunkHeader[0:4]),
+			Offset: offset,
+			Size:   int64(binary.LittleEndian.Uint32(chunkHeader[4:8])),
+		}
+
+		// Writers that stream audio sometimes leave a placeholder size on
+		// the final chunk, so trust the file length over the header.
+		if chunk.DataOffset()+chunk.Size > size {
+			chunk.Size = size - chunk.DataOffset()
+		}
+
+		switch chunk.ID {
+		case "fmt ":
+			format, err := readFormat(r, chunk)
+			if err != nil {
+				return nil, err
+			}
+			f.Format = format
+			haveFormat = true
+		case "data":
+			if f.DataIndex == -1 {
+				f.DataIndex = len(f.Chunks)
+			}
+		}
+
+		f.Chunks = append(f.Chunks, chunk)
+		offset = chunk.End()
+	}
+
+	if !haveFormat {
+		return nil, ErrNoFormat
+	}
+	if f.DataIndex == -1 {
+		return nil, ErrNoData
+	}
+	if f.Format.BlockAlign == 0 {
+		return nil, fmt.Errorf("invalid block alignment in fmt chunk")
+	}
+
+	return f, nil
+}
+
+func readFormat(r io.ReaderAt, chunk Chunk) (Format, error) {
+	var format Format
+
+	if chunk.Size < 16 {
+		return format, fmt.Errorf("fmt chunk too short: %d bytes", chunk.Size)
+	}
+
+	buf := make([]byte, 16)
+	if _, err := r.ReadAt(buf, chunk.DataOffset()); err != nil {
+		return format, fmt.Errorf("failed to read fmt chunk: %w", err)
+	}
+
+	format.AudioFormat = binary.LittleEndian.Uint16(buf[0:2])
+	format.Channels = binary.LittleEndian.Uint16(buf[2:4])
+	format.SampleRate = binary.LittleEndian.Uint32(buf[4:8])
+	format.ByteRate = binary.LittleEndian.Uint32(buf[8:12])
+	format.BlockAlign = binary.LittleEndian.Uint16(buf[12:14])
+	format.BitsPerSample = binary.LittleEndian.Uint16(buf[14:16])
+
+	return format, nil
+}
+
+// RIFFHeader returns the 12-byte file header for a WAV file whose total
+// length is size bytes.
+func RIFFHeader(size int64) []byte {
+	header := make([]byte, riffHeaderSize)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(size-8))
+	copy(header[8:12], "WAVE")
+	return header
+}
+
+func ChunkHeader(id string, size int64) []byte {
+	header := make([]byte, chunkHeaderSize)
+	copy(header[0:4], id)
+	binary.LittleEndian.PutUint32(header[4:8], uint32(size))
+	return header
+}
+
+// ResizedLength is the total file length once the data chunk holds
+// dataSize bytes of samples.
+func (f *File) ResizedLength(dataSize int64) int64 {
+	size := int64(riffHeaderSize)
+	for i, c := range f.Chunks {
+		if i == f.DataIndex {
+			size += chunkHeaderSize + dataSize + dataSize%2
+		} else {
+			size += c.End() - c.Offset
+		}
+	}
+	return size
+}
+
+// Rewrite copies the file from src to w, replacing the contents of the
+// data chunk with whatever writeData produces. Every other chunk is kept
+// in place, and the RIFF and data chunk sizes are updated to match.
+func (f *File) Rewrite(w io.Writer, src io.ReaderAt, dataSize int64, writeData func(io.Writer) error) error {
+	if _, err := w.Write(RIFFHeader(f.ResizedLength(dataSize))); err != nil {
+		return err
+	}
+
+	for i, c := range f.Chunks {
+		size := c.Size
+		if i == f.DataIndex {
+			size = dataSize
+		}
+
+		if _, err := w.Write(ChunkHeader(c.ID, size)); err != nil {
+			return err
+		}
+
+		if i == f.DataIndex {
+			cw := &countingWriter{w: w}
+			if err := writeData(cw); err != nil {
+				return err
+			}
+			if cw.n != dataSize {
+				return fmt.Errorf("data chunk size mismatch: expected %d bytes, wrote %d", dataSize, cw.n)
+			}
+		} else {
+			if _, err := io.Copy(w, io.NewSectionReader(src, c.DataOffset(), c.Size)); err != nil {
+				return err
+			}
+		}
+
+		if size%2 == 1 {
+			if _, err := w.Write([]byte{0}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+var ErrUnsupportedFormat = errors.New("unsupported sample format")
+
+// Format tags found in the fmt chunk
+const (
+	FormatPCM        = 1
+	FormatFloat      = 3
+	FormatExtensible = 0xFFFE
+)
+
+// SampleDecoder returns a function reading one little-endian sample in
+// this format as a value in [-1, 1].
+func (f Format) SampleDecoder() (func([]byte) float64, error) {
+	if f.AudioFormat == FormatFloat {
+		if f.BitsPerSample != 32 {
+			return nil, fmt.Errorf("%w: %d-bit float", ErrUnsupportedFormat, f.BitsPerSample)
+		}
+		return func(b []byte) float64 {
+			v := float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+			return max(-1, min(1, v))
+		}, nil
+	}
+
+	if f.AudioFormat != FormatPCM && f.AudioFormat != FormatExtensible {
+		return nil, fmt.Errorf("%w: format tag %d", ErrUnsupportedFormat, f.AudioFormat)
+	}
+
+	switch f.BitsPerSample {
+	case 8:
+		return func(b []byte) float64 { return float64(int(b[0])-128) / (1 << 7) }, nil
+	case 16:
+		return func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }, nil
+	case 24:
+		return func(b []byte) float64 {
+			v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			return float64(v) / (1 << 23)
+		}, nil
+	case 32:
+		return func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }, nil
+	default:
+		return nil, fmt.Errorf("%w: %d-bit PCM", ErrUnsupportedFormat, f.BitsPerSample)
+	}
+}
+
+// Header returns the header of a plain PCM WAV file holding dataSize
+// bytes of samples in format.
+func Header(format Format, dataSize int64) []byte {
+	fmtChunk := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtChunk[0:2], format.AudioFormat)
+	binary.LittleEndian.PutUint16(fmtChunk[2:4], format.Channels)
+	binary.LittleEndian.PutUint32(fmtChunk[4:8], format.SampleRate)
+	binary.LittleEndian.PutUint32(fmtChunk[8:12], format.ByteRate)
+	binary.LittleEndian.PutUint16(fmtChunk[12:14], format.BlockAlign)
+	binary.LittleEndian.PutUint16(fmtChunk[14:16], format.BitsPerSample)
+
+	size := int64(riffHeaderSize) + chunkHeaderSize + 16 + chunkHeaderSize + dataSize + dataSize%2
+
+	header := RIFFHeader(size)
+	header = append(header, ChunkHeader("fmt ", 16)...)
+	header = append(header, fmtChunk...)
+	header = append(header, ChunkHeader("data", dataSize)...)
+	return header
+}